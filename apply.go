@@ -0,0 +1,175 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// lockNotAvailable is the SQLSTATE reported when lock_timeout is reached
+const lockNotAvailable = "55P03"
+
+// applyMigration runs the statements of a migration on a dedicated connection with the configured
+// timeouts. Unless the migration opts out or contains a statement that can't run inside a
+// transaction block, all statements run in a single transaction, which is retried as a whole on lock
+// timeouts. Otherwise only the statement that timed out is retried. Transactional migrations are
+// marked as started inside their transaction, so a migration that is rolled back is left pending
+// instead of in progress.
+func applyMigration(ctx context.Context, session executor, m Migration, startedAt time.Time, o options) error {
+	conn, release, err := session.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection for migration %s: %s", m.Id, err)
+	}
+	defer release()
+
+	lockTimeout, statementTimeout := o.lockTimeout, o.statementTimeout
+	if m.LockTimeout != 0 {
+		lockTimeout = m.LockTimeout
+	}
+	if m.StatementTimeout != 0 {
+		statementTimeout = m.StatementTimeout
+	}
+
+	if m.Batch != nil {
+		if err := markAsStarted(conn, m.Id, startedAt); err != nil {
+			return err
+		}
+		return applyBatchUpdate(ctx, conn, m, lockTimeout, statementTimeout, o)
+	}
+
+	if !m.NoTransaction {
+		index, reason, err := findNonTransactionalStatement(ctx, conn, m.Statements)
+		if err != nil {
+			return fmt.Errorf("failed to classify statements of migration %s: %s", m.Id, err)
+		}
+		if index >= 0 {
+			o.logger.Info("running migration outside of a transaction", logKeyMigrationId, m.Id, logKeyStatementIndex, index, logKeyReason, reason)
+			m.NoTransaction = true
+		}
+	}
+
+	if m.NoTransaction {
+		if err := markAsStarted(conn, m.Id, startedAt); err != nil {
+			return err
+		}
+		if err := setTimeouts(ctx, conn, "set", lockTimeout, statementTimeout); err != nil {
+			return fmt.Errorf("failed to set timeouts for migration %s: %s", m.Id, err)
+		}
+		// Only reset what was set, so settings made by hooks on the connection survive
+		if lockTimeout > 0 {
+			defer conn.Exec(context.Background(), "reset lock_timeout;")
+		}
+		if statementTimeout > 0 {
+			defer conn.Exec(context.Background(), "reset statement_timeout;")
+		}
+		completed, err := getStatementProgress(ctx, conn, m)
+		if err != nil {
+			return err
+		}
+		if completed > 0 {
+			o.logger.Info("resuming migration", logKeyMigrationId, m.Id, logKeyStatementIndex, completed)
+		}
+		for i, s := range m.Statements[completed:] {
+			i += completed
+			err := retryOnLockTimeout(ctx, m.Id, o, func() error {
+				ctx, span := startStatementSpan(ctx, o.tracer, m.Id, i, s)
+				err := conn.Exec(ctx, s)
+				endSpan(span, err)
+				return err
+			})
+			if err != nil {
+				o.logger.Error("statement failed", logKeyMigrationId, m.Id, logKeyStatementIndex, i, logKeyError, err)
+				return StatementError{MigrationId: m.Id, Index: i, Err: err}
+			}
+			if err := recordStatementProgress(ctx, conn, m, i+1); err != nil {
+				return err
+			}
+		}
+		return clearStatementProgress(ctx, conn, m.Id)
+	}
+
+	var failedIndex int
+	err = retryOnLockTimeout(ctx, m.Id, o, func() error {
+		failedIndex = -1
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(context.Background())
+		if err := setTimeouts(ctx, tx, "set local", lockTimeout, statementTimeout); err != nil {
+			return err
+		}
+		if err := markAsStarted(tx, m.Id, startedAt); err != nil {
+			return err
+		}
+		for i, s := range m.Statements {
+			ctx, span := startStatementSpan(ctx, o.tracer, m.Id, i, s)
+			err := tx.Exec(ctx, s)
+			endSpan(span, err)
+			if err != nil {
+				failedIndex = i
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	})
+	if err != nil && failedIndex >= 0 {
+		o.logger.Error("statement failed", logKeyMigrationId, m.Id, logKeyStatementIndex, failedIndex, logKeyError, err)
+		return StatementError{MigrationId: m.Id, Index: failedIndex, Err: err}
+	} else if err != nil {
+		return fmt.Errorf("failed to apply migration %s: %s", m.Id, err)
+	}
+	return nil
+}
+
+func setTimeouts(ctx context.Context, session querier, command string, lockTimeout, statementTimeout time.Duration) error {
+	if lockTimeout > 0 {
+		if err := session.Exec(ctx, fmt.Sprintf("%s lock_timeout = '%dms';", command, lockTimeout.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	if statementTimeout > 0 {
+		if err := session.Exec(ctx, fmt.Sprintf("%s statement_timeout = '%dms';", command, statementTimeout.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func retryOnLockTimeout(ctx context.Context, migrationId string, o options, fn func() error) (err error) {
+	backoff := o.lockRetryBackoff
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil || !isLockTimeout(err) || attempt >= o.lockRetries {
+			return
+		}
+		o.logger.Warn("lock timeout, retrying migration", logKeyMigrationId, migrationId, logKeyAttempt, attempt+1, logKeyBackoff, backoff, logKeyError, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func isLockTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == lockNotAvailable
+}
+
+type StatementError struct {
+	MigrationId string
+	Index       int
+	Err         error
+}
+
+func (e StatementError) Error() string {
+	return fmt.Sprintf("failed to process statement %d in migration %s: %s", e.Index, e.MigrationId, e.Err)
+}
+
+func (e StatementError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,109 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Baseline records all versioned migrations up to and including the migration with the given id as
+// completed without executing them. It is meant for adopting pgmigrate on a database whose schema
+// already matches those migrations and refuses to run if the migrations table contains any records.
+func Baseline[S Session](
+	session S,
+	migrations []Migration,
+	id string,
+	opts ...Option,
+) (baselined []string, err error) {
+	return baseline(context.Background(), newExecutor(session), migrations, id, newOptions(opts))
+}
+
+func baseline(
+	ctx context.Context,
+	session executor,
+	migrations []Migration,
+	id string,
+	o options,
+) (baselined []string, err error) {
+	index := slices.IndexFunc(migrations, func(m Migration) bool { return m.Id == id && !m.Repeatable })
+	if index < 0 {
+		err = fmt.Errorf("baseline migration %s does not exist", id)
+		return
+	}
+
+	conn, releaseConn, err := acquireConn(ctx, session, o.schema)
+	if err != nil {
+		return
+	}
+	defer releaseConn()
+	session = conn
+
+	// Block concurrent runs from recording migrations until the baseline is committed
+	release, err := acquireLock(ctx, session, schemaLockId(o.schema), o.logger)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	if err = createSchema(session, o.schema); err != nil {
+		return
+	}
+	if err = initMigrationsTable(session); err != nil {
+		err = fmt.Errorf("failed to create migrations table: %v", err)
+		return
+	}
+
+	tx, err := session.Begin(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to begin baseline transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(context.Background())
+
+	var count int
+	if err = tx.QueryRow(ctx, "select count(*) from migrations;").Scan(&count); err != nil {
+		err = fmt.Errorf("failed to count migrations: %v", err)
+		return
+	}
+	if count > 0 {
+		err = fmt.Errorf("unable to baseline: migrations table already contains %d records", count)
+		return
+	}
+
+	var now time.Time
+	var user string
+	if err = tx.QueryRow(ctx, "select current_timestamp, current_user;").Scan(&now, &user); err != nil {
+		err = fmt.Errorf("failed to get current time and user: %v", err)
+		return
+	}
+	metadata := newExecutionMetadata(o)
+	var appVersion *string
+	if metadata.appVersion != "" {
+		appVersion = &metadata.appVersion
+	}
+
+	var ids []string
+	var records, history [][]any
+	for _, m := range versionedMigrations(migrations[:index+1]) {
+		records = append(records, []any{m.Id, now, now, true, m.Checksum(), user, metadata.hostname, metadata.toolVersion, appVersion, string(m.phase())})
+		history = append(history, []any{m.Id, string(OperationBaseline), string(OutcomeSuccess), now, now, user, metadata.hostname, metadata.toolVersion, appVersion})
+		ids = append(ids, m.Id)
+	}
+	columns := []string{"id", "started_at", "completed_at", "baseline", "checksum", "applied_by", "hostname", "tool_version", "app_version", "phase"}
+	if err = tx.CopyFrom(ctx, "migrations", columns, records); err != nil {
+		err = fmt.Errorf("failed to baseline migrations: %v", err)
+		return
+	}
+	columns = []string{"migration_id", "operation", "outcome", "started_at", "completed_at", "applied_by", "hostname", "tool_version", "app_version"}
+	if err = tx.CopyFrom(ctx, "migrations_history", columns, history); err != nil {
+		err = fmt.Errorf("failed to record history of baseline: %v", err)
+		return
+	}
+	if err = tx.Commit(ctx); err != nil {
+		err = fmt.Errorf("failed to commit baseline: %v", err)
+		return
+	}
+	baselined = ids
+	return
+}
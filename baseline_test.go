@@ -0,0 +1,100 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestBaseline(t *testing.T) {
+	db, host, port := parentSession(t)
+
+	migrations := []Migration{
+		{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+		{Id: "002", Statements: []string{"create table test_table2(id text)"}},
+		{Id: "003", Statements: []string{"create table test_table3(id text)"}},
+	}
+
+	t.Run("Baseline should record migrations up to the given id as completed without running them", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			baselined, err := Baseline(session, migrations, "002")
+			if err != nil {
+				t.Errorf("unable to baseline: %s", err)
+			}
+			if len(baselined) != 2 || baselined[0] != "001" || baselined[1] != "002" {
+				t.Errorf("expected migrations 001 and 002 to be baselined but got %v", baselined)
+			}
+			verifyTableExistence(t, session, "test_table1", false)
+			verifyTableExistence(t, session, "test_table2", false)
+
+			records, err := getAllRecords(newExecutor(session))
+			if err != nil {
+				t.Errorf("unable to get migration records: %s", err)
+			}
+			for _, r := range records {
+				if !r.baseline || r.completedAt == nil {
+					t.Errorf("expected record %s to be a completed baseline record", r.id)
+				}
+			}
+
+			completed, err := RunMigrations(session, migrations, -1)
+			if err != nil {
+				t.Errorf("failed to run migrations after baseline: %v", err)
+			}
+			if len(completed) != 1 || completed[0] != "003" {
+				t.Errorf("expected only migration 003 to be completed but got %v", completed)
+			}
+		})
+	})
+
+	t.Run("Baseline should refuse to run if the migrations table contains records", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := RunMigrations(session, migrations[:1], -1); err != nil {
+				t.Errorf("unable to run migrations: %s", err)
+			}
+			if _, err := Baseline(session, migrations, "002"); err == nil {
+				t.Errorf("expected baseline to fail on a non-empty migrations table")
+			}
+		})
+	})
+
+	t.Run("Baseline should wait for the advisory lock held by another run", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			conn, err := session.Conn(context.Background())
+			if err != nil {
+				t.Fatalf("unable to get connection: %s", err)
+			}
+			defer conn.Close()
+			if _, err := conn.ExecContext(context.Background(), "select pg_advisory_lock($1)", schemaLockId("")); err != nil {
+				t.Fatalf("unable to take advisory lock: %s", err)
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := Baseline(session, migrations, "002")
+				done <- err
+			}()
+			select {
+			case <-done:
+				t.Errorf("expected baseline to wait for the advisory lock")
+			case <-time.After(200 * time.Millisecond):
+			}
+
+			if _, err := conn.ExecContext(context.Background(), "select pg_advisory_unlock($1)", schemaLockId("")); err != nil {
+				t.Fatalf("unable to release advisory lock: %s", err)
+			}
+			if err := <-done; err != nil {
+				t.Errorf("unable to baseline: %s", err)
+			}
+		})
+	})
+
+	t.Run("Baseline should fail if the given id does not exist", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := Baseline(session, migrations, "004"); err == nil {
+				t.Errorf("expected baseline to fail for unknown migration 004")
+			}
+		})
+	})
+}
@@ -0,0 +1,186 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const defaultBatchSize = 1000
+
+// BatchUpdate backfills a large table in ranges of an integer key column, each updated in its own
+// short transaction, so that rows are never locked for long. Progress is recorded with every batch
+// and a migration that is run again after a crash resumes after the last completed range. Table,
+// Key, Set and Where are inserted into the update statement as they are.
+type BatchUpdate struct {
+	Table string
+	Key   string
+	// Assignments of the update, e.g. "full_name = first_name || ' ' || last_name"
+	Set string
+	// Optional condition that rows must also match, e.g. "full_name is null"
+	Where string
+	// Width of each key range. Defaults to 1000.
+	Size int64
+	// Time to wait between batches
+	Pause time.Duration
+}
+
+// updatePattern matches the update statement of a batch update migration file up to its assignments
+var updatePattern = regexp.MustCompile(`(?is)^update\s+([\w."]+)\s+set\s+(.*?);?$`)
+
+// parseBatchUpdate turns the single update statement of a migration file with a batch-key directive,
+// e.g. "update users set full_name = first_name || ' ' || last_name where full_name is null;", into
+// the table, assignments and condition of its batch update.
+func parseBatchUpdate(m *Migration) error {
+	if m.Batch.Key == "" {
+		return fmt.Errorf("batch-size and batch-pause require batch-key")
+	}
+	if len(m.Statements) != 1 {
+		return fmt.Errorf("a batch update must consist of a single update statement")
+	}
+	match := updatePattern.FindStringSubmatch(strings.TrimSpace(m.Statements[0]))
+	if match == nil {
+		return fmt.Errorf("a batch update must consist of a single update statement")
+	}
+	m.Batch.Table = match[1]
+	m.Batch.Set, m.Batch.Where = splitWhere(match[2])
+	m.Statements = nil
+	return nil
+}
+
+// splitWhere splits s at the first where keyword outside of parentheses and quotes
+func splitWhere(s string) (before string, after string) {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case depth == 0 && i > 0 && isSpace(s[i-1]) && len(s) > i+5 && strings.EqualFold(s[i:i+5], "where") && isSpace(s[i+5]):
+			return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+5:])
+		}
+	}
+	return strings.TrimSpace(s), ""
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n'
+}
+
+// validateBatchUpdates rejects migrations that declare statements besides their batch update, as
+// the statements would never run.
+func validateBatchUpdates(migrations []Migration) error {
+	for _, m := range migrations {
+		if m.Batch != nil && len(m.Statements) > 0 {
+			return fmt.Errorf("migration %s has both a batch update and statements", m.Id)
+		}
+	}
+	return nil
+}
+
+const createBatchProgressTable = `create table if not exists migrations_batch_progress(
+	migration_id varchar(255) primary key,
+	checksum varchar(64) not null,
+	last_key bigint not null,
+	updated_at timestamptz not null
+);`
+
+// applyBatchUpdate runs the batch update of a migration up to the largest key found when it starts.
+// Rows inserted with larger keys afterwards are left to the application. It fails if the migration
+// changed since the progress of an earlier run was recorded.
+func applyBatchUpdate(ctx context.Context, conn executor, m Migration, lockTimeout, statementTimeout time.Duration, o options) error {
+	b := m.Batch
+	size := b.Size
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+
+	var minKey, maxKey *int64
+	if err := conn.QueryRow(ctx, fmt.Sprintf("select min(%s), max(%s) from %s;", b.Key, b.Key, b.Table)).Scan(&minKey, &maxKey); err != nil {
+		return fmt.Errorf("failed to get key range of migration %s: %s", m.Id, err)
+	}
+	if maxKey == nil {
+		return clearBatchProgress(ctx, conn, m.Id)
+	}
+	var checksum string
+	var lastKey int64
+	q := "select coalesce(max(checksum), ''), coalesce(max(last_key), $2) from migrations_batch_progress where migration_id = $1;"
+	if err := conn.QueryRow(ctx, q, m.Id, *minKey-1).Scan(&checksum, &lastKey); err != nil {
+		return fmt.Errorf("failed to get progress of migration %s: %s", m.Id, err)
+	}
+	if checksum != "" && checksum != m.Checksum() {
+		return PartiallyAppliedBatchUpdateError{Id: m.Id, LastKey: lastKey}
+	}
+	if lastKey >= *minKey {
+		o.logger.Info("resuming batch update", logKeyMigrationId, m.Id, logKeyLastKey, lastKey, logKeyMaxKey, *maxKey)
+	}
+
+	update := fmt.Sprintf("update %s set %s where %s > $1 and %s <= $2", b.Table, b.Set, b.Key, b.Key)
+	if b.Where != "" {
+		update += " and (" + b.Where + ")"
+	}
+	progress := "insert into migrations_batch_progress (migration_id, checksum, last_key, updated_at) values ($1, $2, $3, current_timestamp) on conflict (migration_id) do update set checksum = excluded.checksum, last_key = excluded.last_key, updated_at = excluded.updated_at;"
+	for batch := 0; lastKey < *maxKey; batch++ {
+		upperKey := min(lastKey+size, *maxKey)
+		err := retryOnLockTimeout(ctx, m.Id, o, func() error {
+			tx, err := conn.Begin(ctx)
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback(context.Background())
+			if err := setTimeouts(ctx, tx, "set local", lockTimeout, statementTimeout); err != nil {
+				return err
+			}
+			ctx, span := startStatementSpan(ctx, o.tracer, m.Id, batch, update)
+			err = tx.Exec(ctx, update, lastKey, upperKey)
+			endSpan(span, err)
+			if err != nil {
+				return err
+			}
+			if err := tx.Exec(ctx, progress, m.Id, m.Checksum(), upperKey); err != nil {
+				return err
+			}
+			return tx.Commit(ctx)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update keys %d to %d in migration %s: %s", lastKey+1, upperKey, m.Id, err)
+		}
+		o.logger.Debug("batch updated", logKeyMigrationId, m.Id, logKeyLastKey, upperKey, logKeyMaxKey, *maxKey)
+		lastKey = upperKey
+
+		if lastKey < *maxKey && b.Pause > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(b.Pause):
+			}
+		}
+	}
+	return clearBatchProgress(ctx, conn, m.Id)
+}
+
+func clearBatchProgress(ctx context.Context, session querier, migrationId string) error {
+	if err := session.Exec(ctx, "delete from migrations_batch_progress where migration_id = $1;", migrationId); err != nil {
+		return fmt.Errorf("failed to clear progress of migration %s: %s", migrationId, err)
+	}
+	return nil
+}
+
+type PartiallyAppliedBatchUpdateError struct {
+	Id      string
+	LastKey int64
+}
+
+func (e PartiallyAppliedBatchUpdateError) Error() string {
+	return fmt.Sprintf("migration %s changed after keys up to %d were updated, restore it or clear its progress with repair", e.Id, e.LastKey)
+}
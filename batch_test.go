@@ -0,0 +1,168 @@
+package pgmigrate
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBatchUpdate(t *testing.T) {
+	t.Run("Checksum should ignore the size and pause of a batch update", func(t *testing.T) {
+		m := Migration{Id: "001", Batch: &BatchUpdate{Table: "users", Key: "id", Set: "name = upper(name)", Size: 10}}
+		tuned := Migration{Id: "001", Batch: &BatchUpdate{Table: "users", Key: "id", Set: "name = upper(name)", Size: 100, Pause: time.Second}}
+		changed := Migration{Id: "001", Batch: &BatchUpdate{Table: "users", Key: "id", Set: "name = lower(name)", Size: 10}}
+		if m.Checksum() != tuned.Checksum() {
+			t.Errorf("expected size and pause to not change the checksum")
+		}
+		if m.Checksum() == changed.Checksum() || m.Checksum() == (Migration{Id: "001"}).Checksum() {
+			t.Errorf("expected the update to change the checksum")
+		}
+	})
+
+	t.Run("parseBatchUpdate should split the update statement of a migration file", func(t *testing.T) {
+		tests := []struct {
+			statement string
+			want      BatchUpdate
+			wantErr   bool
+		}{
+			{
+				statement: "update users set name = upper(name);",
+				want:      BatchUpdate{Table: "users", Key: "id", Set: "name = upper(name)"},
+			},
+			{
+				statement: "UPDATE public.users SET name = (select name from names where names.id = users.id) WHERE name is null;",
+				want:      BatchUpdate{Table: "public.users", Key: "id", Set: "name = (select name from names where names.id = users.id)", Where: "name is null"},
+			},
+			{
+				statement: "update users set note = 'where' where id <> 5",
+				want:      BatchUpdate{Table: "users", Key: "id", Set: "note = 'where'", Where: "id <> 5"},
+			},
+			{
+				statement: "delete from users;",
+				wantErr:   true,
+			},
+		}
+		for _, tt := range tests {
+			m := Migration{Id: "001", Statements: []string{tt.statement}, Batch: &BatchUpdate{Key: "id"}}
+			err := parseBatchUpdate(&m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBatchUpdate(%q) error = %v, wantErr %t", tt.statement, err, tt.wantErr)
+			}
+			if err == nil && (*m.Batch != tt.want || m.Statements != nil) {
+				t.Errorf("parseBatchUpdate(%q) = %+v, want %+v", tt.statement, *m.Batch, tt.want)
+			}
+		}
+	})
+
+	t.Run("validateBatchUpdates should reject statements besides a batch update", func(t *testing.T) {
+		m := Migration{Id: "001", Statements: []string{"select 1"}, Batch: &BatchUpdate{Table: "users", Key: "id", Set: "name = upper(name)"}}
+		if err := validateBatchUpdates([]Migration{m}); err == nil {
+			t.Errorf("expected migration 001 to be rejected")
+		}
+	})
+
+	db, host, port := parentSession(t)
+
+	setup := Migration{Id: "001", Statements: []string{
+		"create table test_table1(id bigint primary key, name text, upper_name text)",
+		"insert into test_table1 select i, 'name' || i from generate_series(1, 25) as i",
+	}}
+
+	t.Run("RunMigrations should update all rows in batches", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				setup,
+				{Id: "002", Batch: &BatchUpdate{
+					Table: "test_table1",
+					Key:   "id",
+					Set:   "upper_name = upper(name)",
+					Where: "id <> 5",
+					Size:  10,
+					Pause: 10 * time.Millisecond,
+				}},
+			}
+			if _, err := RunMigrations(session, migrations, -1); err != nil {
+				t.Fatalf("failed to run batch update: %v", err)
+			}
+			var updated int
+			if err := session.QueryRow("select count(*) from test_table1 where upper_name = upper(name);").Scan(&updated); err != nil {
+				t.Fatalf("failed to count updated rows: %v", err)
+			}
+			if updated != 24 {
+				t.Errorf("got %d updated rows, wanted 24", updated)
+			}
+			var progress int
+			if err := session.QueryRow("select count(*) from migrations_batch_progress;").Scan(&progress); err != nil || progress != 0 {
+				t.Errorf("expected progress to be cleared but got %d rows: %v", progress, err)
+			}
+		})
+	})
+
+	t.Run("RunMigrations should resume a batch update after the last recorded key", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := RunMigrations(session, []Migration{setup}, -1); err != nil {
+				t.Fatalf("failed to run setup migration: %v", err)
+			}
+			// Simulate a run that crashed after the first batch
+			markAsStarted(newExecutor(session), "002", getCurrentTime(session).Add(-time.Hour))
+			migrations := []Migration{
+				setup,
+				{Id: "002", Batch: &BatchUpdate{Table: "test_table1", Key: "id", Set: "upper_name = upper(name)", Size: 10}},
+			}
+			if _, err := session.Exec("insert into migrations_batch_progress values ('002', $1, 10, current_timestamp);", migrations[1].Checksum()); err != nil {
+				t.Fatalf("failed to record progress: %v", err)
+			}
+			completed, err := RunMigrations(session, migrations, 60)
+			if err != nil {
+				t.Fatalf("failed to resume batch update: %v", err)
+			}
+			if len(completed) != 1 || completed[0] != "002" {
+				t.Errorf("expected migration 002 to be completed but got %v", completed)
+			}
+			var minUpdated int
+			if err := session.QueryRow("select min(id) from test_table1 where upper_name is not null;").Scan(&minUpdated); err != nil {
+				t.Fatalf("failed to look up updated rows: %v", err)
+			}
+			if minUpdated != 11 {
+				t.Errorf("expected rows after key 10 to be updated but the first updated key is %d", minUpdated)
+			}
+		})
+	})
+
+	t.Run("RunMigrations should refuse to resume a batch update that changed", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := RunMigrations(session, []Migration{setup}, -1); err != nil {
+				t.Fatalf("failed to run setup migration: %v", err)
+			}
+			// Simulate a run of an earlier version of the migration that crashed after the first batch
+			markAsStarted(newExecutor(session), "002", getCurrentTime(session).Add(-time.Hour))
+			original := Migration{Id: "002", Batch: &BatchUpdate{Table: "test_table1", Key: "id", Set: "upper_name = upper(name)", Size: 10}}
+			if _, err := session.Exec("insert into migrations_batch_progress values ('002', $1, 10, current_timestamp);", original.Checksum()); err != nil {
+				t.Fatalf("failed to record progress: %v", err)
+			}
+
+			migrations := []Migration{
+				setup,
+				{Id: "002", Batch: &BatchUpdate{Table: "test_table1", Key: "id", Set: "upper_name = lower(name)", Size: 10}},
+			}
+			_, err := RunMigrations(session, migrations, 60)
+			var partialErr PartiallyAppliedBatchUpdateError
+			if !errors.As(err, &partialErr) || partialErr.LastKey != 10 {
+				t.Fatalf("expected PartiallyAppliedBatchUpdateError after key 10 but got %v", err)
+			}
+
+			// Repair clears the progress so the batch update starts over
+			if _, err := Repair(session, migrations, ""); err != nil {
+				t.Fatalf("failed to repair: %v", err)
+			}
+			var progress int
+			if err := session.QueryRow("select count(*) from migrations_batch_progress;").Scan(&progress); err != nil || progress != 0 {
+				t.Errorf("expected progress to be cleared but got %d rows: %v", progress, err)
+			}
+			if _, err := RunMigrations(session, migrations, -1); err != nil {
+				t.Errorf("failed to run repaired migration: %v", err)
+			}
+		})
+	})
+}
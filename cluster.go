@@ -0,0 +1,29 @@
+package pgmigrate
+
+import (
+	"context"
+)
+
+// ClusterSchema is the schema of the maintenance database that tracks cluster migrations. Pass
+// WithSchema(ClusterSchema) to Status, History or Repair to inspect them.
+const ClusterSchema = "pgmigrate_cluster"
+
+// RunClusterMigrations applies migrations that act on the whole cluster, such as creating roles and
+// databases, before any regular migrations run. session must be connected to a maintenance database
+// like postgres. The migrations are tracked in ClusterSchema rather than alongside the regular
+// migrations of that database, and never run in a transaction. They aren't split into phases, so
+// WithPhase is ignored.
+func RunClusterMigrations[S Session](
+	session S,
+	migrations []Migration,
+	retryAfterSeconds int,
+	opts ...Option,
+) (completed []string, err error) {
+	cluster := make([]Migration, len(migrations))
+	for i, m := range migrations {
+		m.NoTransaction = true
+		cluster[i] = m
+	}
+	o := newOptions(append(opts[:len(opts):len(opts)], WithSchema(ClusterSchema), WithPhase("")))
+	return runMigrations(context.Background(), newExecutor(session), cluster, retryAfterSeconds, o)
+}
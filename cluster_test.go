@@ -0,0 +1,52 @@
+package pgmigrate
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestClusterMigrations(t *testing.T) {
+	db, _, _ := parentSession(t)
+
+	t.Run("RunClusterMigrations should create roles and databases outside a transaction", func(t *testing.T) {
+		name := randomUser()
+		defer func() {
+			for _, q := range []string{
+				fmt.Sprintf("drop database if exists %s;", name),
+				fmt.Sprintf("drop role if exists %s;", name),
+				fmt.Sprintf("drop schema if exists %s cascade;", ClusterSchema),
+			} {
+				if _, err := db.Exec(q); err != nil {
+					t.Errorf("failed to clean up: %s", err)
+				}
+			}
+		}()
+
+		migrations := []Migration{
+			{Id: "000", Statements: []string{
+				fmt.Sprintf("create role %s;", name),
+				fmt.Sprintf("create database %s owner %s;", name, name),
+			}},
+		}
+		// Cluster migrations run regardless of the phase chosen for the regular migrations
+		completed, err := RunClusterMigrations(db, migrations, -1, WithPhase(PhaseContract))
+		if err != nil {
+			t.Fatalf("failed to run cluster migrations: %s", err)
+		}
+		if len(completed) != 1 {
+			t.Errorf("expected 1 completed migration but got %v", completed)
+		}
+
+		// Cluster migrations are tracked apart from regular migrations
+		verifyTableExistence(t, db, "migrations", false)
+		statuses, err := Status(db, migrations, -1, WithSchema(ClusterSchema))
+		if err != nil || len(statuses) != 1 || statuses[0].State != StateApplied {
+			t.Errorf("unexpected cluster migration statuses %v: %v", statuses, err)
+		}
+
+		completed, err = RunClusterMigrations(db, migrations, -1)
+		if err != nil || len(completed) != 0 {
+			t.Errorf("expected no migrations to run again but got %v: %v", completed, err)
+		}
+	})
+}
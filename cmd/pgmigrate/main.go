@@ -1,8 +1,46 @@
 package main
 
-import "github.com/emillamm/pgmigrate"
+import (
+	"flag"
+	"os"
+
+	"github.com/emillamm/pgmigrate"
+)
 
 func main() {
-	pgmigrate.Run()
-}
+	command, args := "migrate", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
 
+	switch command {
+	case "migrate":
+		pgmigrate.Run()
+	case "status":
+		flags := flag.NewFlagSet("status", flag.ExitOnError)
+		format := flags.String("format", "table", "output format: table or json")
+		flags.Parse(args)
+		pgmigrate.RunStatus(*format)
+	case "history":
+		flags := flag.NewFlagSet("history", flag.ExitOnError)
+		id := flags.String("id", "", "only show attempts for the migration with this id")
+		format := flags.String("format", "table", "output format: table or json")
+		flags.Parse(args)
+		pgmigrate.RunHistory(*id, *format)
+	case "lint":
+		pgmigrate.RunLint()
+	case "baseline":
+		flags := flag.NewFlagSet("baseline", flag.ExitOnError)
+		id := flags.String("id", "", "id of the last migration to record as completed")
+		flags.Parse(args)
+		pgmigrate.RunBaseline(*id)
+	case "repair":
+		flags := flag.NewFlagSet("repair", flag.ExitOnError)
+		complete := flags.String("complete", "", "id of a migration to mark as completed after fixing it by hand")
+		flags.Parse(args)
+		pgmigrate.RunRepair(*complete)
+	default:
+		pgmigrate.NewLogger().Error("unknown command", "command", command)
+		os.Exit(1)
+	}
+}
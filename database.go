@@ -0,0 +1,59 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// duplicateDatabase is the SQLSTATE reported when a database with the same name already exists
+const duplicateDatabase = "42P04"
+
+// DatabaseOptions are used when CreateDatabaseIfNotExists creates a database. Empty fields use the
+// server defaults.
+type DatabaseOptions struct {
+	Owner    string
+	Encoding string
+	Template string
+}
+
+// CreateDatabaseIfNotExists creates the database unless it already exists and reports whether it was
+// created. session must be connected to another database, e.g. postgres.
+func CreateDatabaseIfNotExists[S Session](session S, name string, opts DatabaseOptions) (created bool, err error) {
+	e := newExecutor(session)
+	ctx := context.Background()
+
+	var exists bool
+	if err = e.QueryRow(ctx, "select exists (select from pg_database where datname = $1);", name).Scan(&exists); err != nil {
+		err = fmt.Errorf("failed to look up database %s: %v", name, err)
+		return
+	}
+	if exists {
+		return
+	}
+
+	q := "create database " + quoteIdentifier(name)
+	if opts.Owner != "" {
+		q += " owner " + quoteIdentifier(opts.Owner)
+	}
+	if opts.Encoding != "" {
+		q += " encoding " + quoteLiteral(opts.Encoding)
+	}
+	if opts.Template != "" {
+		q += " template " + quoteIdentifier(opts.Template)
+	}
+	if err = e.Exec(ctx, q+";"); err != nil {
+		// Another run created the database after it was looked up
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == duplicateDatabase {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("failed to create database %s: %v", name, err)
+		return
+	}
+	created = true
+	return
+}
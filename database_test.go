@@ -0,0 +1,46 @@
+package pgmigrate
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCreateDatabaseIfNotExists(t *testing.T) {
+	db, _, _ := parentSession(t)
+
+	t.Run("CreateDatabaseIfNotExists should create a missing database once", func(t *testing.T) {
+		name := randomUser()
+		if _, err := db.Exec(fmt.Sprintf("create role %s;", name)); err != nil {
+			t.Fatalf("failed to create role: %s", err)
+		}
+		defer func() {
+			for _, q := range []string{
+				fmt.Sprintf("drop database if exists %s;", name),
+				fmt.Sprintf("drop role %s;", name),
+			} {
+				if _, err := db.Exec(q); err != nil {
+					t.Errorf("failed to clean up: %s", err)
+				}
+			}
+		}()
+
+		opts := DatabaseOptions{Owner: name, Encoding: "UTF8", Template: "template0"}
+		created, err := CreateDatabaseIfNotExists(db, name, opts)
+		if err != nil || !created {
+			t.Fatalf("expected database to be created but got created=%t: %v", created, err)
+		}
+		var owner, encoding string
+		q := "select pg_get_userbyid(datdba), pg_encoding_to_char(encoding) from pg_database where datname = $1;"
+		if err := db.QueryRow(q, name).Scan(&owner, &encoding); err != nil {
+			t.Fatalf("failed to look up database: %s", err)
+		}
+		if owner != name || encoding != "UTF8" {
+			t.Errorf("got owner=%s encoding=%s, wanted owner=%s encoding=UTF8", owner, encoding, name)
+		}
+
+		created, err = CreateDatabaseIfNotExists(db, name, opts)
+		if err != nil || created {
+			t.Errorf("expected existing database to be left alone but got created=%t: %v", created, err)
+		}
+	})
+}
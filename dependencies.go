@@ -0,0 +1,153 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// getDependencies returns the ids of the migrations that each versioned migration depends on. It
+// fails if a migration depends on an unknown or repeatable migration or if the dependencies form a
+// cycle.
+// Without declared dependencies, a migration depends on every earlier migration that no other earlier
+// migration depends on, so it waits for all branches of the graph before it. An expand migration only
+// considers earlier expand migrations, so it can be applied before earlier contract migrations.
+func getDependencies(migrations []Migration) (map[string][]string, error) {
+	versioned := versionedMigrations(migrations)
+	dependencies := make(map[string][]string, len(versioned))
+	for i, m := range versioned {
+		if len(m.DependsOn) == 0 {
+			earlier := versioned[:i]
+			if m.phase() == PhaseExpand {
+				earlier = inPhase(earlier, PhaseExpand)
+			}
+			dependencies[m.Id] = leaves(earlier, dependencies)
+		} else {
+			for _, id := range m.DependsOn {
+				if !slices.ContainsFunc(versioned, func(d Migration) bool { return d.Id == id }) {
+					return nil, fmt.Errorf("migration %s depends on unknown migration %s", m.Id, id)
+				}
+			}
+			dependencies[m.Id] = m.DependsOn
+		}
+	}
+	if slices.ContainsFunc(migrations, func(m Migration) bool { return m.Repeatable && len(m.DependsOn) > 0 }) {
+		return nil, fmt.Errorf("repeatable migrations can't declare dependencies")
+	}
+	if _, err := sortByDependencies(versioned, dependencies); err != nil {
+		return nil, err
+	}
+	return dependencies, nil
+}
+
+// leaves returns the ids of the migrations that none of the other migrations depend on, in the
+// provided order.
+func leaves(migrations []Migration, dependencies map[string][]string) (ids []string) {
+	for _, m := range migrations {
+		isDependency := slices.ContainsFunc(migrations, func(other Migration) bool {
+			return slices.Contains(dependencies[other.Id], m.Id)
+		})
+		if !isDependency {
+			ids = append(ids, m.Id)
+		}
+	}
+	return
+}
+
+// maxParallelism returns the number of migrations that can be applied at a time through the pool.
+// The connection that holds the advisory lock counts against the limit of the pool, so a pool of n
+// connections applies at most n-1 migrations at a time, and a pool of a single connection applies
+// them one at a time instead of waiting forever for a second connection.
+func maxParallelism(pool executor, parallelism int) int {
+	if !pool.Pooled() {
+		return 1
+	}
+	if limit := pool.MaxConns(); limit > 0 && parallelism >= limit {
+		return max(limit-1, 1)
+	}
+	return parallelism
+}
+
+// sortByDependencies orders the migrations so that every migration comes after the migrations it
+// depends on, keeping the provided order where dependencies allow it. Dependencies outside of
+// migrations are treated as applied.
+func sortByDependencies(migrations []Migration, dependencies map[string][]string) ([]Migration, error) {
+	remaining := slices.Clone(migrations)
+	sorted := make([]Migration, 0, len(migrations))
+	isRemaining := func(id string) bool {
+		return slices.ContainsFunc(remaining, func(m Migration) bool { return m.Id == id })
+	}
+	for len(remaining) > 0 {
+		i := slices.IndexFunc(remaining, func(m Migration) bool {
+			return !slices.ContainsFunc(dependencies[m.Id], isRemaining)
+		})
+		if i < 0 {
+			var ids []string
+			for _, m := range remaining {
+				ids = append(ids, m.Id)
+			}
+			return nil, fmt.Errorf("dependency cycle between migrations %v", ids)
+		}
+		sorted = append(sorted, remaining[i])
+		remaining = slices.Delete(remaining, i, i+1)
+	}
+	return sorted, nil
+}
+
+type migrationResult struct {
+	id      string
+	applied bool
+	err     error
+}
+
+// runConcurrently applies the migrations on connections of their own, starting each as soon as the
+// migrations it depends on have completed and running at most o.parallelism at a time. After a
+// failure no more migrations are started, but running ones are allowed to finish.
+func runConcurrently(
+	ctx context.Context,
+	pool executor,
+	migrations []Migration,
+	dependencies map[string][]string,
+	o options,
+) (completed []string, err error) {
+	// A dependency is outstanding while it is pending and hasn't completed during this run
+	outstanding := func(id string) bool {
+		return slices.ContainsFunc(migrations, func(m Migration) bool { return m.Id == id }) && !slices.Contains(completed, id)
+	}
+	waiting := slices.Clone(migrations)
+	results := make(chan migrationResult)
+	running := 0
+	for {
+		for err == nil && running < o.parallelism {
+			i := slices.IndexFunc(waiting, func(m Migration) bool {
+				return !slices.ContainsFunc(dependencies[m.Id], outstanding)
+			})
+			if i < 0 {
+				break
+			}
+			m := waiting[i]
+			waiting = slices.Delete(waiting, i, i+1)
+			running++
+			go func() {
+				var applied bool
+				conn, release, err := acquireConn(ctx, pool, o.schema)
+				if err == nil {
+					applied, err = runMigrationWithHooks(ctx, conn, m, o)
+					release()
+				}
+				results <- migrationResult{id: m.Id, applied: applied, err: err}
+			}()
+		}
+		if running == 0 {
+			return
+		}
+		r := <-results
+		running--
+		if r.applied {
+			completed = append(completed, r.id)
+		}
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+	}
+}
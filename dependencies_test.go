@@ -0,0 +1,208 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestDependencies(t *testing.T) {
+	ids := func(migrations []Migration) (ids []string) {
+		for _, m := range migrations {
+			ids = append(ids, m.Id)
+		}
+		return
+	}
+
+	tests := []struct {
+		name       string
+		migrations []Migration
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "implicit dependencies keep the provided order",
+			migrations: []Migration{{Id: "001"}, {Id: "002"}, {Id: "003"}},
+			want:       []string{"001", "002", "003"},
+		},
+		{
+			name:       "declared dependencies may point forward",
+			migrations: []Migration{{Id: "001"}, {Id: "002", DependsOn: []string{"003"}}, {Id: "003", DependsOn: []string{"001"}}},
+			want:       []string{"001", "003", "002"},
+		},
+		{
+			name:       "repeatable migrations are not part of the graph",
+			migrations: []Migration{{Id: "R__views", Repeatable: true}, {Id: "001"}, {Id: "002", DependsOn: []string{"001"}}},
+			want:       []string{"001", "002"},
+		},
+		{
+			name:       "unknown dependency",
+			migrations: []Migration{{Id: "001"}, {Id: "002", DependsOn: []string{"000"}}},
+			wantErr:    true,
+		},
+		{
+			name:       "dependency on a repeatable migration",
+			migrations: []Migration{{Id: "R__views", Repeatable: true}, {Id: "001", DependsOn: []string{"R__views"}}},
+			wantErr:    true,
+		},
+		{
+			name:       "repeatable migration with dependencies",
+			migrations: []Migration{{Id: "001"}, {Id: "R__views", Repeatable: true, DependsOn: []string{"001"}}},
+			wantErr:    true,
+		},
+		{
+			name:       "cycle",
+			migrations: []Migration{{Id: "001", DependsOn: []string{"003"}}, {Id: "002"}, {Id: "003"}},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dependencies, err := getDependencies(tt.migrations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getDependencies() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			sorted, err := sortByDependencies(versionedMigrations(tt.migrations), dependencies)
+			if err != nil {
+				t.Fatalf("sortByDependencies() error = %v", err)
+			}
+			if got := ids(sorted); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("undeclared dependencies should include every earlier leaf", func(t *testing.T) {
+		migrations := []Migration{
+			{Id: "001"},
+			{Id: "002", DependsOn: []string{"001"}},
+			{Id: "003", DependsOn: []string{"001"}},
+			{Id: "004"},
+		}
+		dependencies, err := getDependencies(migrations)
+		if err != nil {
+			t.Fatalf("getDependencies() error = %v", err)
+		}
+		want := map[string][]string{"001": nil, "002": {"001"}, "003": {"001"}, "004": {"002", "003"}}
+		if !reflect.DeepEqual(dependencies, want) {
+			t.Errorf("got %v, want %v", dependencies, want)
+		}
+	})
+
+	t.Run("maxParallelism should leave a connection of the pool for the advisory lock", func(t *testing.T) {
+		db, err := sql.Open("pgx", "postgres://localhost/postgres")
+		if err != nil {
+			t.Fatalf("unable to open database: %s", err)
+		}
+		defer db.Close()
+		if got := maxParallelism(newExecutor(db), 4); got != 4 {
+			t.Errorf("got parallelism %d for an unlimited pool, wanted 4", got)
+		}
+		db.SetMaxOpenConns(3)
+		if got := maxParallelism(newExecutor(db), 4); got != 2 {
+			t.Errorf("got parallelism %d for a pool of 3 connections, wanted 2", got)
+		}
+		db.SetMaxOpenConns(1)
+		if got := maxParallelism(newExecutor(db), 4); got != 1 {
+			t.Errorf("got parallelism %d for a pool of 1 connection, wanted 1", got)
+		}
+
+		pool, err := pgxpool.New(context.Background(), "postgres://localhost/postgres?pool_max_conns=1")
+		if err != nil {
+			t.Fatalf("unable to create pool: %s", err)
+		}
+		defer pool.Close()
+		if got := maxParallelism(newExecutor(pool), 4); got != 1 {
+			t.Errorf("got parallelism %d for a pgx pool of 1 connection, wanted 1", got)
+		}
+	})
+
+	db, host, port := parentSession(t)
+
+	t.Run("RunMigrations should apply independent migrations concurrently", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			// 002 and 003 record when they run so the test can tell whether they overlapped
+			logged := func(id string) []string {
+				return []string{
+					"insert into test_log (id, started_at) values ('" + id + "', clock_timestamp())",
+					"select pg_sleep(0.5)",
+					"update test_log set ended_at = clock_timestamp() where id = '" + id + "'",
+				}
+			}
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_log(id text primary key, started_at timestamptz, ended_at timestamptz)"}},
+				{Id: "002", Statements: logged("002"), DependsOn: []string{"001"}},
+				{Id: "003", Statements: logged("003"), DependsOn: []string{"001"}},
+				// Without a declaration, 004 depends on both 002 and 003
+				{Id: "004", Statements: []string{"insert into test_log (id, started_at) values ('004', clock_timestamp())"}},
+			}
+			completed, err := RunMigrations(session, migrations, -1, WithParallelism(2))
+			if err != nil {
+				t.Fatalf("failed to run migrations: %v", err)
+			}
+			if len(completed) != 4 || completed[0] != "001" || completed[3] != "004" {
+				t.Errorf("expected 001 first and 004 last but got %v", completed)
+			}
+
+			started := make(map[string]time.Time)
+			ended := make(map[string]time.Time)
+			rows, err := session.Query("select id, started_at, coalesce(ended_at, started_at) from test_log")
+			if err != nil {
+				t.Fatalf("unable to read test log: %s", err)
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var id string
+				var start, end time.Time
+				if err := rows.Scan(&id, &start, &end); err != nil {
+					t.Fatalf("unable to scan test log: %s", err)
+				}
+				started[id], ended[id] = start, end
+			}
+			if !started["002"].Before(ended["003"]) || !started["003"].Before(ended["002"]) {
+				t.Errorf("expected 002 and 003 to overlap but got %v-%v and %v-%v", started["002"], ended["002"], started["003"], ended["003"])
+			}
+			if started["004"].Before(ended["002"]) || started["004"].Before(ended["003"]) {
+				t.Errorf("expected 004 to start after 002 and 003 ended but it started at %v", started["004"])
+			}
+		})
+	})
+
+	t.Run("RunMigrations should not wait for a second connection of a pool of one connection", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			session.SetMaxOpenConns(1)
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"create table test_table2(id text)"}, DependsOn: []string{"001"}},
+				{Id: "003", Statements: []string{"create table test_table3(id text)"}, DependsOn: []string{"001"}},
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if _, err := RunMigrationsContext(ctx, session, migrations, -1, WithParallelism(2)); err != nil {
+				t.Fatalf("failed to run migrations: %v", err)
+			}
+			verifyTableExistence(t, session, "test_table3", true)
+		})
+	})
+
+	t.Run("RunMigrations should not start dependents of a failed migration", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"invalid"}},
+				{Id: "002", Statements: []string{"create table test_table1(id text)"}},
+			}
+			if _, err := RunMigrations(session, migrations, -1, WithParallelism(4)); err == nil {
+				t.Errorf("expected migration 001 to fail")
+			}
+			verifyTableExistence(t, session, "test_table1", false)
+		})
+	})
+}
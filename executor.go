@@ -0,0 +1,317 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Session is a database handle that migrations can be run against. A *pgx.Conn is used for the
+// whole run, including holding the advisory lock, so it must not be used concurrently.
+type Session interface {
+	*sql.DB | *pgx.Conn | *pgxpool.Pool
+}
+
+type row interface {
+	Scan(dest ...any) error
+}
+
+type rows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+	Close()
+}
+
+type batchQuery struct {
+	query string
+	args  []any
+}
+
+// querier is implemented by every executor and transaction
+type querier interface {
+	Exec(ctx context.Context, query string, args ...any) error
+	Query(ctx context.Context, query string, args ...any) (rows, error)
+	QueryRow(ctx context.Context, query string, args ...any) row
+	// SendBatch runs the queries in order. pgx pipelines them in a single round trip.
+	SendBatch(ctx context.Context, queries []batchQuery) error
+	// CopyFrom inserts rows into table. pgx uses the copy protocol, database/sql an insert per row.
+	CopyFrom(ctx context.Context, table string, columns []string, values [][]any) error
+}
+
+// executor hides whether migrations are run through database/sql or natively through pgx
+type executor interface {
+	querier
+	Begin(ctx context.Context) (transaction, error)
+	// Acquire returns an executor that runs every query on the same connection. Connections that
+	// are not pooled return themselves.
+	Acquire(ctx context.Context) (conn executor, release func(), err error)
+	// Pooled reports whether Acquire hands out separate connections that can be used concurrently
+	Pooled() bool
+	// MaxConns returns the number of connections that Acquire hands out at most at the same time,
+	// or 0 if there is no limit
+	MaxConns() int
+}
+
+type transaction interface {
+	querier
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+func newExecutor[S Session](session S) executor {
+	switch s := any(session).(type) {
+	case *sql.DB:
+		return sqlDB{sqlQuerier{s}, s}
+	case *pgx.Conn:
+		return pgxConn{pgxQuerier{s}}
+	case *pgxpool.Pool:
+		return pgxPool{pgxQuerier{s}, s}
+	}
+	panic(fmt.Sprintf("unsupported session type %T", session))
+}
+
+type sqlSession interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type sqlQuerier struct {
+	session sqlSession
+}
+
+func (q sqlQuerier) Exec(ctx context.Context, query string, args ...any) error {
+	_, err := q.session.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (q sqlQuerier) Query(ctx context.Context, query string, args ...any) (rows, error) {
+	r, err := q.session.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sqlRows{r}, nil
+}
+
+func (q sqlQuerier) QueryRow(ctx context.Context, query string, args ...any) row {
+	return q.session.QueryRowContext(ctx, query, args...)
+}
+
+func (q sqlQuerier) SendBatch(ctx context.Context, queries []batchQuery) error {
+	// Like a pgx batch, the queries run in a single transaction unless they already are in one
+	if session, ok := q.session.(sqlBeginner); ok {
+		tx, err := session.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := (sqlQuerier{tx}).SendBatch(ctx, queries); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	for _, bq := range queries {
+		if _, err := q.session.ExecContext(ctx, bq.query, bq.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q sqlQuerier) CopyFrom(ctx context.Context, table string, columns []string, values [][]any) error {
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	query := fmt.Sprintf("insert into %s (%s) values (%s);", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	for _, v := range values {
+		if _, err := q.session.ExecContext(ctx, query, v...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sqlRows struct {
+	*sql.Rows
+}
+
+func (r sqlRows) Close() {
+	r.Rows.Close()
+}
+
+type sqlDB struct {
+	sqlQuerier
+	db *sql.DB
+}
+
+func (e sqlDB) Begin(ctx context.Context) (transaction, error) {
+	return beginSQL(ctx, e.db)
+}
+
+func (e sqlDB) Pooled() bool {
+	return true
+}
+
+func (e sqlDB) MaxConns() int {
+	return e.db.Stats().MaxOpenConnections
+}
+
+func (e sqlDB) Acquire(ctx context.Context) (executor, func(), error) {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sqlConn{sqlQuerier{conn}, conn}, func() { conn.Close() }, nil
+}
+
+type sqlConn struct {
+	sqlQuerier
+	conn *sql.Conn
+}
+
+func (e sqlConn) Begin(ctx context.Context) (transaction, error) {
+	return beginSQL(ctx, e.conn)
+}
+
+func (e sqlConn) Pooled() bool {
+	return false
+}
+
+func (e sqlConn) MaxConns() int {
+	return 1
+}
+
+func (e sqlConn) Acquire(ctx context.Context) (executor, func(), error) {
+	return e, func() {}, nil
+}
+
+// sqlBeginner is implemented by *sql.DB and *sql.Conn
+type sqlBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+func beginSQL(ctx context.Context, session sqlBeginner) (transaction, error) {
+	tx, err := session.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sqlTx{sqlQuerier{tx}, tx}, nil
+}
+
+type sqlTx struct {
+	sqlQuerier
+	tx *sql.Tx
+}
+
+func (t sqlTx) Commit(ctx context.Context) error {
+	return t.tx.Commit()
+}
+
+func (t sqlTx) Rollback(ctx context.Context) error {
+	return t.tx.Rollback()
+}
+
+// pgxSession is implemented by *pgx.Conn, *pgxpool.Pool, *pgxpool.Conn and pgx.Tx
+type pgxSession interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+type pgxQuerier struct {
+	session pgxSession
+}
+
+func (q pgxQuerier) Exec(ctx context.Context, query string, args ...any) error {
+	_, err := q.session.Exec(ctx, query, args...)
+	return err
+}
+
+func (q pgxQuerier) Query(ctx context.Context, query string, args ...any) (rows, error) {
+	return q.session.Query(ctx, query, args...)
+}
+
+func (q pgxQuerier) QueryRow(ctx context.Context, query string, args ...any) row {
+	return q.session.QueryRow(ctx, query, args...)
+}
+
+func (q pgxQuerier) SendBatch(ctx context.Context, queries []batchQuery) error {
+	batch := &pgx.Batch{}
+	for _, bq := range queries {
+		batch.Queue(bq.query, bq.args...)
+	}
+	return q.session.SendBatch(ctx, batch).Close()
+}
+
+func (q pgxQuerier) CopyFrom(ctx context.Context, table string, columns []string, values [][]any) error {
+	_, err := q.session.CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(values))
+	return err
+}
+
+func (q pgxQuerier) Begin(ctx context.Context) (transaction, error) {
+	tx, err := q.session.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pgxTx{pgxQuerier{tx}, tx}, nil
+}
+
+type pgxConn struct {
+	pgxQuerier
+}
+
+func (e pgxConn) Pooled() bool {
+	return false
+}
+
+func (e pgxConn) MaxConns() int {
+	return 1
+}
+
+func (e pgxConn) Acquire(ctx context.Context) (executor, func(), error) {
+	return e, func() {}, nil
+}
+
+type pgxPool struct {
+	pgxQuerier
+	pool *pgxpool.Pool
+}
+
+func (e pgxPool) Pooled() bool {
+	return true
+}
+
+func (e pgxPool) MaxConns() int {
+	return int(e.pool.Config().MaxConns)
+}
+
+func (e pgxPool) Acquire(ctx context.Context) (executor, func(), error) {
+	conn, err := e.pool.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pgxConn{pgxQuerier{conn}}, conn.Release, nil
+}
+
+type pgxTx struct {
+	pgxQuerier
+	tx pgx.Tx
+}
+
+func (t pgxTx) Commit(ctx context.Context) error {
+	return t.tx.Commit(ctx)
+}
+
+func (t pgxTx) Rollback(ctx context.Context) error {
+	return t.tx.Rollback(ctx)
+}
@@ -0,0 +1,123 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+func TestPgxSessions(t *testing.T) {
+	db, host, port := parentSession(t)
+
+	migrations := []Migration{
+		{Id: "001", Statements: []string{"create table test_table1(id text)", "insert into test_table1 values ('a')"}},
+		{Id: "002", Statements: []string{"create index concurrently test_table1_idx on test_table1 (id)"}, NoTransaction: true},
+		{Id: "R__view", Statements: []string{"create or replace view test_view as select id from test_table1"}, Repeatable: true},
+	}
+
+	t.Run("RunMigrations should run migrations on a *pgx.Conn", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			withPgxConn(t, session, func(conn *pgx.Conn) {
+				completed, err := RunMigrations(conn, migrations, -1)
+				if err != nil {
+					t.Fatalf("unable to run migrations: %s", err)
+				}
+				if len(completed) != 3 {
+					t.Errorf("got %v completed migrations, wanted 3", completed)
+				}
+				statuses, err := Status(conn, migrations, -1)
+				if err != nil {
+					t.Fatalf("unable to get status: %s", err)
+				}
+				for _, s := range statuses {
+					if s.State != StateApplied {
+						t.Errorf("expected migration %s to be applied but got %s", s.Id, s.State)
+					}
+				}
+				entries, err := History(conn, "")
+				if err != nil {
+					t.Fatalf("unable to get history: %s", err)
+				}
+				if len(entries) != 3 {
+					t.Errorf("got %d history entries, wanted 3", len(entries))
+				}
+			})
+		})
+	})
+
+	t.Run("RunMigrations should run migrations on a *pgxpool.Pool", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			withPgxConn(t, session, func(conn *pgx.Conn) {
+				config, err := pgxpool.ParseConfig("")
+				if err != nil {
+					t.Fatalf("unable to create pool config: %s", err)
+				}
+				config.ConnConfig = conn.Config()
+				pool, err := pgxpool.NewWithConfig(context.Background(), config)
+				if err != nil {
+					t.Fatalf("unable to create pool: %s", err)
+				}
+				defer pool.Close()
+
+				if _, err := Baseline(pool, migrations, "001"); err != nil {
+					t.Fatalf("unable to baseline: %s", err)
+				}
+				completed, err := RunMigrations(pool, migrations, -1)
+				if err != nil {
+					t.Fatalf("unable to run migrations: %s", err)
+				}
+				if len(completed) != 2 || completed[0] != "002" || completed[1] != "R__view" {
+					t.Errorf("got %v completed migrations, wanted [002 R__view]", completed)
+				}
+				records, err := getAllRecords(newExecutor(pool))
+				if err != nil {
+					t.Fatalf("unable to get records: %s", err)
+				}
+				if len(records) != 3 || !records[0].baseline || records[0].checksum == nil || *records[0].checksum != migrations[0].Checksum() {
+					t.Errorf("unexpected records %v", records)
+				}
+			})
+		})
+	})
+
+	t.Run("SendBatch should apply all queries or none on a *sql.DB", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			e := newExecutor(session)
+			if err := e.Exec(context.Background(), "create table test_table1(id text)"); err != nil {
+				t.Fatalf("unable to create table: %s", err)
+			}
+			queries := []batchQuery{
+				{"insert into test_table1 values ($1)", []any{"a"}},
+				{"insert into test_table2 values ($1)", []any{"b"}},
+			}
+			if err := e.SendBatch(context.Background(), queries); err == nil {
+				t.Fatalf("expected batch to fail")
+			}
+			var count int
+			if err := session.QueryRow("select count(*) from test_table1;").Scan(&count); err != nil || count != 0 {
+				t.Errorf("expected the batch to be rolled back but got %d rows: %v", count, err)
+			}
+		})
+	})
+}
+
+// withPgxConn runs block with the native pgx connection underlying a *sql.DB connection
+func withPgxConn(t testing.TB, session *sql.DB, block func(conn *pgx.Conn)) {
+	t.Helper()
+	conn, err := session.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("unable to get connection: %s", err)
+	}
+	defer conn.Close()
+	err = conn.Raw(func(driverConn any) error {
+		block(driverConn.(*stdlib.Conn).Conn())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to get pgx connection: %s", err)
+	}
+}
@@ -0,0 +1,167 @@
+package pgmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+type Operation string
+
+const (
+	OperationApply    Operation = "apply"
+	OperationBaseline Operation = "baseline"
+	OperationRepair   Operation = "repair"
+)
+
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+)
+
+// HistoryEntry is a single attempt to apply, baseline or repair a migration. Entries are never
+// updated or removed.
+type HistoryEntry struct {
+	Id             int64      `json:"id"`
+	MigrationId    string     `json:"migration_id"`
+	Operation      Operation  `json:"operation"`
+	Outcome        Outcome    `json:"outcome"`
+	StartedAt      time.Time  `json:"started_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	StatementIndex *int       `json:"statement_index,omitempty"`
+	AppliedBy      string     `json:"applied_by,omitempty"`
+	Hostname       string     `json:"hostname,omitempty"`
+	ToolVersion    string     `json:"tool_version,omitempty"`
+	AppVersion     string     `json:"app_version,omitempty"`
+}
+
+const createHistoryTable = `create table if not exists migrations_history(
+	id bigserial primary key,
+	migration_id varchar(255) not null,
+	operation varchar(32) not null,
+	outcome varchar(32) not null,
+	started_at timestamptz not null,
+	completed_at timestamptz,
+	error text,
+	statement_index integer,
+	applied_by text,
+	hostname text,
+	tool_version text,
+	app_version text
+);`
+
+// History returns all recorded attempts for the migration with the given id, or for all
+// migrations if id is empty, in the order they were recorded.
+func History[S Session](session S, id string, opts ...Option) (entries []HistoryEntry, err error) {
+	o := newOptions(opts)
+	conn, release, err := acquireConn(context.Background(), newExecutor(session), o.schema)
+	if err != nil {
+		return
+	}
+	defer release()
+	return getHistory(conn, o.schema, id)
+}
+
+func getHistory(session querier, schema string, id string) (entries []HistoryEntry, err error) {
+	exists, err := tableExists(session, schema, "migrations_history")
+	if err != nil {
+		err = fmt.Errorf("failed to look up migrations history table: %v", err)
+		return
+	}
+	if !exists {
+		return
+	}
+
+	q := `select id, migration_id, operation, outcome, started_at, completed_at, coalesce(error, ''), statement_index,
+		coalesce(applied_by, ''), coalesce(hostname, ''), coalesce(tool_version, ''), coalesce(app_version, '')
+		from migrations_history where $1 = '' or migration_id = $1 order by id`
+	rows, err := session.Query(context.Background(), q, id)
+	if err != nil {
+		err = fmt.Errorf("failed to read migrations history: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e HistoryEntry
+		if err = rows.Scan(&e.Id, &e.MigrationId, &e.Operation, &e.Outcome, &e.StartedAt, &e.CompletedAt, &e.Error,
+			&e.StatementIndex, &e.AppliedBy, &e.Hostname, &e.ToolVersion, &e.AppVersion); err != nil {
+			err = fmt.Errorf("failed to scan rows in migrations history table: %s", err)
+			return
+		}
+		entries = append(entries, e)
+	}
+	err = rows.Err()
+	return
+}
+
+// recordHistory appends an attempt to the history table. The statement index is taken from err if
+// it is a StatementError.
+func recordHistory(
+	session querier,
+	migrationId string,
+	operation Operation,
+	startedAt time.Time,
+	metadata executionMetadata,
+	err error,
+) error {
+	bq := historyQuery(migrationId, operation, startedAt, metadata, err)
+	if execErr := session.Exec(context.Background(), bq.query, bq.args...); execErr != nil {
+		return fmt.Errorf("failed to record history of migration %s: %s", migrationId, execErr)
+	}
+	return nil
+}
+
+func historyQuery(
+	migrationId string,
+	operation Operation,
+	startedAt time.Time,
+	metadata executionMetadata,
+	err error,
+) batchQuery {
+	outcome, message := OutcomeSuccess, ""
+	var statementIndex *int
+	if err != nil {
+		outcome, message = OutcomeFailure, err.Error()
+		var statementErr StatementError
+		if errors.As(err, &statementErr) {
+			statementIndex = &statementErr.Index
+		}
+	}
+	q := `insert into migrations_history (migration_id, operation, outcome, started_at, completed_at, error, statement_index,
+		applied_by, hostname, tool_version, app_version)
+		values ($1, $2, $3, $4, current_timestamp, nullif($5, ''), $6, current_user, $7, $8, nullif($9, ''));`
+	args := []any{migrationId, string(operation), string(outcome), startedAt, message, statementIndex,
+		metadata.hostname, metadata.toolVersion, metadata.appVersion}
+	return batchQuery{q, args}
+}
+
+func WriteHistoryTable(w io.Writer, entries []HistoryEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tMIGRATION ID\tOPERATION\tOUTCOME\tSTARTED AT\tCOMPLETED AT\tSTATEMENT\tAPPLIED BY\tHOST\tERROR")
+	for _, e := range entries {
+		statementIndex := "-"
+		if e.StatementIndex != nil {
+			statementIndex = strconv.Itoa(*e.StatementIndex)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Id, e.MigrationId, e.Operation, e.Outcome,
+			formatTime(&e.StartedAt), formatTime(e.CompletedAt), statementIndex, orDash(e.AppliedBy), orDash(e.Hostname), orDash(e.Error))
+	}
+	return tw.Flush()
+}
+
+func WriteHistoryJSON(w io.Writer, entries []HistoryEntry) error {
+	if entries == nil {
+		entries = []HistoryEntry{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
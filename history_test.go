@@ -0,0 +1,157 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	db, host, port := parentSession(t)
+
+	t.Run("History should be empty if the history table doesn't exist", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			entries, err := History(session, "")
+			if err != nil {
+				t.Errorf("unable to get history: %s", err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("expected no entries but got %v", entries)
+			}
+			verifyTableExistence(t, session, "migrations_history", false)
+		})
+	})
+
+	t.Run("History should keep failed attempts after a migration succeeds", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			failing := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"create table test_table2(id text)", "invalid"}},
+			}
+			if _, err := RunMigrations(session, failing, -1); err == nil {
+				t.Fatalf("expected migration 002 to fail")
+			}
+			fixed := []Migration{
+				failing[0],
+				{Id: "002", Statements: []string{"create table test_table2(id text)"}},
+			}
+			if _, err := RunMigrations(session, fixed, -1); err != nil {
+				t.Fatalf("unable to run migrations: %s", err)
+			}
+
+			entries, err := History(session, "002")
+			if err != nil {
+				t.Fatalf("unable to get history: %s", err)
+			}
+			if len(entries) != 2 {
+				t.Fatalf("got %d entries, wanted 2: %v", len(entries), entries)
+			}
+			failed, succeeded := entries[0], entries[1]
+			if failed.Operation != OperationApply || failed.Outcome != OutcomeFailure || failed.Error == "" {
+				t.Errorf("unexpected failed entry %v", failed)
+			}
+			if failed.StatementIndex == nil || *failed.StatementIndex != 1 {
+				t.Errorf("expected failed entry to have statement index 1 but got %v", failed.StatementIndex)
+			}
+			if succeeded.Outcome != OutcomeSuccess || succeeded.Error != "" || succeeded.StatementIndex != nil || succeeded.CompletedAt == nil {
+				t.Errorf("unexpected successful entry %v", succeeded)
+			}
+
+			all, err := History(session, "")
+			if err != nil {
+				t.Fatalf("unable to get history: %s", err)
+			}
+			if len(all) != 3 {
+				t.Errorf("got %d entries, wanted 3: %v", len(all), all)
+			}
+		})
+	})
+
+	t.Run("Baseline and Repair should be recorded in the history", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"create table test_table2(id text)"}},
+			}
+			if _, err := Baseline(session, migrations, "001"); err != nil {
+				t.Fatalf("unable to baseline: %s", err)
+			}
+			markAsStarted(newExecutor(session), "002", getCurrentTime(session))
+			if _, err := Repair(session, migrations, "002"); err != nil {
+				t.Fatalf("unable to repair: %s", err)
+			}
+
+			entries, err := History(session, "")
+			if err != nil {
+				t.Fatalf("unable to get history: %s", err)
+			}
+			if len(entries) != 2 ||
+				entries[0].MigrationId != "001" || entries[0].Operation != OperationBaseline ||
+				entries[1].MigrationId != "002" || entries[1].Operation != OperationRepair {
+				t.Errorf("unexpected entries %v", entries)
+			}
+		})
+	})
+}
+
+func TestWriteHistory(t *testing.T) {
+	startedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	completedAt := startedAt.Add(2 * time.Second)
+	index := 1
+	entries := []HistoryEntry{
+		{
+			Id:             1,
+			MigrationId:    "001",
+			Operation:      OperationApply,
+			Outcome:        OutcomeFailure,
+			StartedAt:      startedAt,
+			CompletedAt:    &completedAt,
+			Error:          "syntax",
+			StatementIndex: &index,
+			AppliedBy:      "postgres",
+			Hostname:       "pod-1",
+		},
+		{Id: 2, MigrationId: "001", Operation: OperationApply, Outcome: OutcomeSuccess, StartedAt: startedAt, CompletedAt: &completedAt},
+	}
+
+	t.Run("WriteHistoryTable should print a row per entry", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteHistoryTable(&buf, entries); err != nil {
+			t.Fatalf("unable to write table: %s", err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("expected header and 2 rows but got %q", buf.String())
+		}
+		if got := strings.Fields(lines[1]); strings.Join(got, " ") != "1 001 apply failure 2024-01-02T03:04:05Z 2024-01-02T03:04:07Z 1 postgres pod-1 syntax" {
+			t.Errorf("unexpected row %q", lines[1])
+		}
+		if got := strings.Fields(lines[2]); strings.Join(got, " ") != "2 001 apply success 2024-01-02T03:04:05Z 2024-01-02T03:04:07Z - - - -" {
+			t.Errorf("unexpected row %q", lines[2])
+		}
+	})
+
+	t.Run("WriteHistoryJSON should print a JSON array", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteHistoryJSON(&buf, nil); err != nil {
+			t.Fatalf("unable to write json: %s", err)
+		}
+		if strings.TrimSpace(buf.String()) != "[]" {
+			t.Errorf("expected empty array but got %q", buf.String())
+		}
+		buf.Reset()
+		if err := WriteHistoryJSON(&buf, entries); err != nil {
+			t.Fatalf("unable to write json: %s", err)
+		}
+		var got []HistoryEntry
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("invalid json %q: %s", buf.String(), err)
+		}
+		if len(got) != 2 || got[0].StatementIndex == nil || *got[0].StatementIndex != 1 || got[1].Outcome != OutcomeSuccess {
+			t.Errorf("unexpected entries %v", got)
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Hooks are called around a migration run. An error returned from BeforeAll or BeforeEach aborts the
+// run. AfterEach and AfterAll are called with the outcome of a migration and of the run respectively.
+type Hooks interface {
+	BeforeAll() error
+	BeforeEach(m Migration) error
+	AfterEach(m Migration, duration time.Duration, err error)
+	AfterAll(completed []string, err error)
+}
+
+// NopHooks implements Hooks without doing anything. Embed it to implement only some of the hooks.
+type NopHooks struct{}
+
+func (NopHooks) BeforeAll() error                                         { return nil }
+func (NopHooks) BeforeEach(m Migration) error                             { return nil }
+func (NopHooks) AfterEach(m Migration, duration time.Duration, err error) {}
+func (NopHooks) AfterAll(completed []string, err error)                   {}
+
+// SQLHooks holds statements that are run around a migration run. The after hooks only run when the
+// migration or the run completed successfully.
+type SQLHooks struct {
+	BeforeAll  []string
+	BeforeEach []string
+	AfterEach  []string
+	AfterAll   []string
+}
+
+func execHookStatements(session querier, logger *slog.Logger, name string, statements []string) error {
+	for i, s := range statements {
+		if err := session.Exec(context.Background(), s); err != nil {
+			logger.Error("hook failed", logKeyHook, name, logKeyStatementIndex, i, logKeyError, err)
+			return fmt.Errorf("failed to process statement %d in %s hook: %s", i, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package pgmigrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type recordingHooks struct {
+	NopHooks
+	calls         []string
+	beforeEachErr error
+}
+
+func (h *recordingHooks) BeforeAll() error {
+	h.calls = append(h.calls, "beforeAll")
+	return nil
+}
+
+func (h *recordingHooks) BeforeEach(m Migration) error {
+	h.calls = append(h.calls, "beforeEach "+m.Id)
+	return h.beforeEachErr
+}
+
+func (h *recordingHooks) AfterEach(m Migration, duration time.Duration, err error) {
+	h.calls = append(h.calls, fmt.Sprintf("afterEach %s %t", m.Id, err == nil))
+}
+
+func (h *recordingHooks) AfterAll(completed []string, err error) {
+	h.calls = append(h.calls, fmt.Sprintf("afterAll %v %t", completed, err == nil))
+}
+
+func TestHooks(t *testing.T) {
+	db, host, port := parentSession(t)
+
+	t.Run("RunMigrations should call hooks around the run and each migration", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"create table test_table2(id text)", "invalid"}},
+			}
+			hooks := &recordingHooks{}
+			if _, err := RunMigrations(session, migrations, -1, WithHooks(hooks)); err == nil {
+				t.Errorf("expected migration 002 to fail")
+			}
+			want := []string{
+				"beforeAll",
+				"beforeEach 001",
+				"afterEach 001 true",
+				"beforeEach 002",
+				"afterEach 002 false",
+				"afterAll [001] false",
+			}
+			if !reflect.DeepEqual(hooks.calls, want) {
+				t.Errorf("got %v, want %v", hooks.calls, want)
+			}
+		})
+	})
+
+	t.Run("RunMigrations should abort if a before hook fails", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+			}
+			hooks := &recordingHooks{beforeEachErr: errors.New("not now")}
+			completed, err := RunMigrations(session, migrations, -1, WithHooks(hooks))
+			if err == nil {
+				t.Errorf("expected before each hook to abort the run")
+			}
+			if len(completed) > 0 {
+				t.Errorf("expected no completed migrations")
+			}
+			verifyTableExistence(t, session, "test_table1", false)
+		})
+	})
+
+	t.Run("RunMigrations should report a failing after each SQL hook without failing the migration", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"create table test_table2(id text)"}},
+			}
+			hooks := &recordingHooks{}
+			completed, err := RunMigrations(session, migrations, -1, WithHooks(hooks), WithSQLHooks(SQLHooks{AfterEach: []string{"invalid"}}))
+			if err == nil {
+				t.Errorf("expected the after each hook to stop the run")
+			}
+			if len(completed) != 1 || completed[0] != "001" {
+				t.Errorf("expected migration 001 to be completed but got %v", completed)
+			}
+			want := []string{
+				"beforeAll",
+				"beforeEach 001",
+				"afterEach 001 true",
+				"afterAll [001] false",
+			}
+			if !reflect.DeepEqual(hooks.calls, want) {
+				t.Errorf("got %v, want %v", hooks.calls, want)
+			}
+			verifyTableExistence(t, session, "test_table2", false)
+		})
+	})
+
+	t.Run("RunMigrations should run SQL hooks", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"create table test_table2(id text)"}},
+			}
+			hooks := SQLHooks{
+				BeforeAll:  []string{"create table hook_calls(name text)"},
+				BeforeEach: []string{"insert into hook_calls values ('beforeEach')"},
+				AfterEach:  []string{"insert into hook_calls values ('afterEach')"},
+				AfterAll:   []string{"insert into hook_calls values ('afterAll')"},
+			}
+			if _, err := RunMigrations(session, migrations, -1, WithSQLHooks(hooks)); err != nil {
+				t.Errorf("failed to run migrations: %v", err)
+			}
+			var count int
+			if err := session.QueryRow("select count(*) from hook_calls").Scan(&count); err != nil {
+				t.Errorf("unable to count hook calls: %s", err)
+			}
+			if count != 5 {
+				t.Errorf("expected 5 hook calls but got %d", count)
+			}
+		})
+	})
+
+	t.Run("RunMigrations should keep timeouts set by SQL hooks for migrations without timeouts", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", NoTransaction: true, Statements: []string{"create table test_table1(id text)"}},
+			}
+			hooks := SQLHooks{
+				BeforeAll: []string{"create table hook_settings(lock_timeout text)", "set lock_timeout = '5s'"},
+				AfterAll:  []string{"insert into hook_settings select current_setting('lock_timeout')"},
+			}
+			if _, err := RunMigrations(session, migrations, -1, WithSQLHooks(hooks)); err != nil {
+				t.Errorf("failed to run migrations: %v", err)
+			}
+			var lockTimeout string
+			if err := session.QueryRow("select lock_timeout from hook_settings").Scan(&lockTimeout); err != nil {
+				t.Errorf("unable to get lock timeout: %s", err)
+			}
+			if lockTimeout != "5s" {
+				t.Errorf("expected lock timeout set by the hook to be kept but got %s", lockTimeout)
+			}
+		})
+	})
+}
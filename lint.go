@@ -0,0 +1,170 @@
+package pgmigrate
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// LintRule flags a statement that is known to block or break a live database
+type LintRule struct {
+	Id          string
+	Explanation string
+	// check reports whether the statement violates the rule. created holds the tables created by
+	// earlier statements of the same migration, which nothing else can be using yet.
+	check func(m Migration, statement string, created []string) bool
+}
+
+type LintViolation struct {
+	MigrationId string
+	// Index of the statement within the migration, starting at 0
+	Statement int
+	Rule      string
+	Message   string
+}
+
+var (
+	createTablePattern   = regexp.MustCompile(`(?i)^create\s+(?:(?:global\s+|local\s+)?(?:temp|temporary|unlogged)\s+)?table\s+(?:if\s+not\s+exists\s+)?([\w."]+)`)
+	createIndexPattern   = regexp.MustCompile(`(?i)^create\s+(?:unique\s+)?index\s+(concurrently\s+)?.*?\bon\s+(?:only\s+)?([\w."]+)`)
+	alterTablePattern    = regexp.MustCompile(`(?i)^alter\s+table\s+(?:if\s+exists\s+)?(?:only\s+)?([\w."]+)\s+(.*)$`)
+	addColumnPattern     = regexp.MustCompile(`(?i)^add\s`)
+	addConstraintPattern = regexp.MustCompile(`(?i)^add\s+(?:constraint|primary|unique|foreign|check|exclude)\b`)
+	defaultPattern       = regexp.MustCompile(`(?i)\bdefault\s+(.*)`)
+	notNullPattern       = regexp.MustCompile(`(?i)\bnot\s+null\b`)
+	volatilePattern      = regexp.MustCompile(`(?i)\b(random|gen_random_uuid|uuid_generate_v[14]|clock_timestamp|timeofday|nextval)\s*\(`)
+	alterTypePattern     = regexp.MustCompile(`(?i)^alter\s+(?:column\s+)?[\w"]+\s+(?:set\s+data\s+)?type\b`)
+	dropColumnPattern    = regexp.MustCompile(`(?i)^drop\s+column\b`)
+)
+
+// LintRules are the rules checked by Lint
+var LintRules = []LintRule{
+	{
+		Id:          "PGM001",
+		Explanation: "create index without concurrently blocks writes to the table until the index is built",
+		check: func(m Migration, statement string, created []string) bool {
+			match := createIndexPattern.FindStringSubmatch(statement)
+			return match != nil && match[1] == "" && !isCreatedTable(created, match[2])
+		},
+	},
+	{
+		Id:          "PGM002",
+		Explanation: "adding a column with a volatile default rewrites the table while holding an access exclusive lock",
+		check: alterTableCheck(func(action string) bool {
+			if !isAddColumn(action) {
+				return false
+			}
+			def := defaultPattern.FindStringSubmatch(action)
+			return def != nil && volatilePattern.MatchString(def[1])
+		}),
+	},
+	{
+		Id:          "PGM003",
+		Explanation: "adding a not null column without a default fails as soon as the table has rows",
+		check: alterTableCheck(func(action string) bool {
+			return isAddColumn(action) && notNullPattern.MatchString(action) && !defaultPattern.MatchString(action)
+		}),
+	},
+	{
+		Id:          "PGM004",
+		Explanation: "changing the type of a column usually rewrites the table while holding an access exclusive lock",
+		check: alterTableCheck(func(action string) bool {
+			return alterTypePattern.MatchString(action)
+		}),
+	},
+	{
+		Id:          "PGM005",
+		Explanation: "dropping a column breaks application code that still uses it; drop it in a contract migration once no deployed code reads it",
+		check: func(m Migration, statement string, created []string) bool {
+			return m.phase() != PhaseContract && alterTableCheck(func(action string) bool {
+				return dropColumnPattern.MatchString(action)
+			})(m, statement, created)
+		},
+	},
+}
+
+// alterTableCheck returns a check that reports whether any action of an alter table statement on a
+// table that existed before the migration matches.
+func alterTableCheck(matches func(action string) bool) func(Migration, string, []string) bool {
+	return func(m Migration, statement string, created []string) bool {
+		match := alterTablePattern.FindStringSubmatch(strings.TrimSuffix(statement, ";"))
+		if match == nil || isCreatedTable(created, match[1]) {
+			return false
+		}
+		return slices.ContainsFunc(splitActions(match[2]), matches)
+	}
+}
+
+// splitActions splits the comma separated actions of an alter table statement, ignoring commas
+// within parentheses and quotes.
+func splitActions(s string) (actions []string) {
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == ',' && depth == 0:
+			actions = append(actions, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(actions, strings.TrimSpace(s[start:]))
+}
+
+func isAddColumn(action string) bool {
+	return addColumnPattern.MatchString(action) && !addConstraintPattern.MatchString(action)
+}
+
+func isCreatedTable(created []string, table string) bool {
+	return slices.Contains(created, strings.ToLower(table))
+}
+
+func isLintRule(id string) bool {
+	return slices.ContainsFunc(LintRules, func(r LintRule) bool { return r.Id == id })
+}
+
+// Lint checks the statements of the migrations against LintRules. Rules listed in a migration's
+// LintIgnore are skipped for that migration.
+func Lint(migrations []Migration) (violations []LintViolation) {
+	for _, m := range migrations {
+		var created []string
+		for i, statement := range m.Statements {
+			statement = strings.TrimSpace(statement)
+			for _, rule := range LintRules {
+				if slices.Contains(m.LintIgnore, rule.Id) || !rule.check(m, statement, created) {
+					continue
+				}
+				violations = append(violations, LintViolation{
+					MigrationId: m.Id,
+					Statement:   i,
+					Rule:        rule.Id,
+					Message:     rule.Explanation,
+				})
+			}
+			if match := createTablePattern.FindStringSubmatch(statement); match != nil {
+				created = append(created, strings.ToLower(match[1]))
+			}
+		}
+	}
+	return
+}
+
+func WriteLintReport(w io.Writer, violations []LintViolation) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MIGRATION\tSTATEMENT\tRULE\tMESSAGE")
+	for _, v := range violations {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", v.MigrationId, v.Statement, v.Rule, v.Message)
+	}
+	return tw.Flush()
+}
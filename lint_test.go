@@ -0,0 +1,105 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name      string
+		migration Migration
+		want      []string
+	}{
+		{
+			name:      "create index",
+			migration: Migration{Statements: []string{"create index test_idx on test_table1 (id);"}},
+			want:      []string{"PGM001"},
+		},
+		{
+			name:      "create index concurrently",
+			migration: Migration{Statements: []string{"create unique index concurrently test_idx on test_table1 (id);"}},
+		},
+		{
+			name: "create index on a table created in the same migration",
+			migration: Migration{Statements: []string{
+				"create table if not exists test_table1(id text);",
+				"create index test_idx on test_table1 (id);",
+			}},
+		},
+		{
+			name:      "add column with a volatile default",
+			migration: Migration{Statements: []string{"alter table test_table1 add column uuid uuid not null default gen_random_uuid();"}},
+			want:      []string{"PGM002"},
+		},
+		{
+			name:      "add column with a constant default",
+			migration: Migration{Statements: []string{"alter table test_table1 add column created_at timestamptz not null default now();"}},
+		},
+		{
+			name:      "add not null column without a default",
+			migration: Migration{Statements: []string{"alter table test_table1 add column name text, add column count int not null;"}},
+			want:      []string{"PGM003"},
+		},
+		{
+			name:      "add constraint",
+			migration: Migration{Statements: []string{"alter table test_table1 add constraint name_not_null check (name is not null) not valid;"}},
+		},
+		{
+			name:      "alter column type",
+			migration: Migration{Statements: []string{"alter table only test_table1 alter column id type bigint;"}},
+			want:      []string{"PGM004"},
+		},
+		{
+			name:      "alter column default",
+			migration: Migration{Statements: []string{"alter table test_table1 alter column id set default 'a';"}},
+		},
+		{
+			name:      "drop column",
+			migration: Migration{Statements: []string{"alter table test_table1 drop column name;"}},
+			want:      []string{"PGM005"},
+		},
+		{
+			name:      "drop column in a contract migration",
+			migration: Migration{Statements: []string{"alter table test_table1 drop column name;"}, Phase: PhaseContract},
+		},
+		{
+			name: "ignored rules",
+			migration: Migration{
+				Statements: []string{"create index test_idx on test_table1 (id);", "alter table test_table1 alter id type bigint;"},
+				LintIgnore: []string{"PGM001"},
+			},
+			want: []string{"PGM004"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range Lint([]Migration{tt.migration}) {
+				got = append(got, v.Rule)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteLintReport(t *testing.T) {
+	violations := []LintViolation{{MigrationId: "001", Statement: 0, Rule: "PGM001", Message: "create index without concurrently"}}
+	var buf bytes.Buffer
+	if err := WriteLintReport(&buf, violations); err != nil {
+		t.Fatalf("unable to write report: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected header and 1 row but got %q", buf.String())
+	}
+	// Statements are numbered from 0 like everywhere else
+	if got := strings.Fields(lines[1]); strings.Join(got[:3], " ") != "001 0 PGM001" {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+}
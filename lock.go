@@ -0,0 +1,54 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"time"
+)
+
+// advisoryLockId identifies the session level advisory lock held while migrations are run or repaired.
+const advisoryLockId int64 = 0x70676d6967726174 // "pgmigrat"
+
+// acquireLock blocks until the advisory lock is held on a dedicated connection. The returned
+// function releases the lock and returns the connection to the pool.
+func acquireLock(ctx context.Context, session executor, lockId int64, logger *slog.Logger) (release func(), err error) {
+	conn, releaseConn, err := session.Acquire(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to get connection for advisory lock: %v", err)
+		return
+	}
+	var acquired bool
+	if err = conn.QueryRow(ctx, "select pg_try_advisory_lock($1);", lockId).Scan(&acquired); err != nil {
+		releaseConn()
+		err = fmt.Errorf("failed to acquire advisory lock: %v", err)
+		return
+	}
+	if !acquired {
+		logger.Info("waiting for migration lock")
+		start := time.Now()
+		if err = conn.Exec(ctx, "select pg_advisory_lock($1);", lockId); err != nil {
+			releaseConn()
+			err = fmt.Errorf("failed to acquire advisory lock: %v", err)
+			return
+		}
+		logger.Info("acquired migration lock", logKeyDuration, time.Since(start))
+	}
+	release = func() {
+		conn.Exec(context.Background(), "select pg_advisory_unlock($1);", lockId)
+		releaseConn()
+	}
+	return
+}
+
+// schemaLockId returns the advisory lock id for runs in the given schema, so that runs in different
+// schemas don't block each other.
+func schemaLockId(schema string) int64 {
+	if schema == "" {
+		return advisoryLockId
+	}
+	h := fnv.New64a()
+	h.Write([]byte(schema))
+	return advisoryLockId ^ int64(h.Sum64())
+}
@@ -0,0 +1,34 @@
+package pgmigrate
+
+// Attribute keys shared by all log events
+const (
+	logKeyMigrationId    = "migration_id"
+	logKeyMigrationIds   = "migration_ids"
+	logKeyStatementIndex = "statement_index"
+	logKeyDuration       = "duration"
+	logKeyError          = "error"
+	logKeyAttempt        = "attempt"
+	logKeyBackoff        = "backoff"
+	logKeyHook           = "hook"
+	logKeyHost           = "host"
+	logKeyPort           = "port"
+	logKeyDatabase       = "database"
+	logKeyUser           = "user"
+	logKeySchema         = "schema"
+	logKeyReason         = "reason"
+	logKeyTarget         = "target"
+	logKeyValue          = "value"
+	logKeyFormat         = "format"
+	logKeyPath           = "path"
+	logKeyStartedAt      = "started_at"
+	logKeyChecksum       = "checksum"
+	logKeyLatestApplied  = "latest_applied"
+	logKeyLastKey        = "last_key"
+	logKeyMaxKey         = "max_key"
+	logKeySchemas        = "schemas"
+	logKeyFailed         = "failed"
+	logKeyCleared        = "cleared"
+	logKeyCompleted      = "completed"
+	logKeyRestamped      = "restamped"
+	logKeyParallelism    = "parallelism"
+)
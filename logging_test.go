@@ -0,0 +1,76 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogging(t *testing.T) {
+	db, host, port := parentSession(t)
+
+	t.Run("RunMigrations should log events with consistent attributes to the given logger", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"invalid"}},
+			}
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, nil))
+			if _, err := RunMigrations(session, migrations, -1, WithLogger(logger)); err == nil {
+				t.Errorf("expected migration 002 to fail")
+			}
+
+			var events []map[string]any
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var event map[string]any
+				if err := json.Unmarshal([]byte(line), &event); err != nil {
+					t.Fatalf("invalid log line %q: %s", line, err)
+				}
+				events = append(events, event)
+			}
+
+			find := func(msg string, id string) map[string]any {
+				for _, e := range events {
+					if e["msg"] == msg && e[logKeyMigrationId] == id {
+						return e
+					}
+				}
+				t.Errorf("missing %q event for migration %s in %v", msg, id, events)
+				return nil
+			}
+			if e := find("migration completed", "001"); e != nil && e[logKeyDuration] == nil {
+				t.Errorf("expected duration in %v", e)
+			}
+			if e := find("statement failed", "002"); e != nil && (e[logKeyStatementIndex] != float64(0) || e[logKeyError] == nil) {
+				t.Errorf("expected statement index and error in %v", e)
+			}
+			find("migration failed", "002")
+		})
+	})
+
+	t.Run("RunMigrations should log which hook failed", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{{Id: "001", Statements: []string{"create table test_table1(id text)"}}}
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, nil))
+			hooks := SQLHooks{AfterEach: []string{"select 1", "invalid"}}
+			if _, err := RunMigrations(session, migrations, -1, WithLogger(logger), WithSQLHooks(hooks)); err == nil {
+				t.Errorf("expected the after each hook to fail")
+			}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var event map[string]any
+				if err := json.Unmarshal([]byte(line), &event); err != nil {
+					t.Fatalf("invalid log line %q: %s", line, err)
+				}
+				if event["msg"] == "hook failed" && event[logKeyHook] == "after each" && event[logKeyStatementIndex] == float64(1) {
+					return
+				}
+			}
+			t.Errorf("missing hook failed event for the after each hook in %s", buf.String())
+		})
+	})
+}
@@ -0,0 +1,169 @@
+package pgmigrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// manifest describes several databases on the same cluster that are migrated in one run. Settings
+// that a target leaves empty are taken from the environment.
+type manifest struct {
+	Parallel bool             `json:"parallel"`
+	Targets  []manifestTarget `json:"targets"`
+}
+
+type manifestTarget struct {
+	Name             string   `json:"name"`
+	Database         string   `json:"database"`
+	Directory        string   `json:"directory"`
+	OutOfOrder       Policy   `json:"out_of_order"`
+	Missing          Policy   `json:"missing"`
+	LockTimeout      string   `json:"lock_timeout"`
+	StatementTimeout string   `json:"statement_timeout"`
+	AppVersion       string   `json:"app_version"`
+	Schemas          []string `json:"schemas"`
+}
+
+func loadManifest(path string) (m manifest, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		err = fmt.Errorf("failed to open manifest: %v", err)
+		return
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&m); err != nil {
+		err = fmt.Errorf("failed to parse manifest %s: %v", path, err)
+		return
+	}
+	if len(m.Targets) == 0 {
+		err = fmt.Errorf("manifest %s has no targets", path)
+		return
+	}
+	names := make(map[string]bool)
+	for i := range m.Targets {
+		t := &m.Targets[i]
+		if t.Database == "" || t.Directory == "" {
+			err = fmt.Errorf("target %d in manifest %s must have a database and a directory", i, path)
+			return
+		}
+		if info, statErr := os.Stat(t.Directory); statErr != nil || !info.IsDir() {
+			err = fmt.Errorf("directory %s of target %d in manifest %s does not exist", t.Directory, i, path)
+			return
+		}
+		if t.Name == "" {
+			t.Name = t.Database
+		}
+		if names[t.Name] {
+			err = fmt.Errorf("target %s appears more than once in manifest %s", t.Name, path)
+			return
+		}
+		names[t.Name] = true
+	}
+	return
+}
+
+// apply returns a copy of c with the settings of the target.
+func (t manifestTarget) apply(c config) (config, error) {
+	c.database = t.Database
+	c.migrationDir = t.Directory
+	for _, p := range []struct {
+		value  Policy
+		target *Policy
+	}{{t.OutOfOrder, &c.outOfOrder}, {t.Missing, &c.missing}} {
+		if p.value == "" {
+			continue
+		}
+		policy, err := ParsePolicy(string(p.value))
+		if err != nil {
+			return c, fmt.Errorf("invalid policy in target %s: %v", t.Name, err)
+		}
+		*p.target = policy
+	}
+	for _, d := range []struct {
+		value  string
+		target *time.Duration
+	}{{t.LockTimeout, &c.lockTimeout}, {t.StatementTimeout, &c.statementTimeout}} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return c, fmt.Errorf("invalid timeout in target %s: %v", t.Name, err)
+		}
+		*d.target = duration
+	}
+	if t.AppVersion != "" {
+		c.appVersion = t.AppVersion
+	}
+	if len(t.Schemas) > 0 {
+		c.schemas = t.Schemas
+	}
+	c.logger = c.logger.With(logKeyTarget, t.Name)
+	return c, nil
+}
+
+type targetResult struct {
+	name      string
+	database  string
+	completed []string
+	duration  time.Duration
+	err       error
+}
+
+// runManifest migrates every target of the manifest, in parallel if requested, and returns the
+// result of each target in manifest order. A failing target doesn't stop the others.
+func (c config) runManifest(m manifest, opts []Option) []targetResult {
+	results := make([]targetResult, len(m.Targets))
+	run := func(i int) {
+		t := m.Targets[i]
+		results[i] = targetResult{name: t.Name, database: t.Database}
+		start := time.Now()
+		tc, err := t.apply(c)
+		if err == nil {
+			results[i].completed, err = tc.migrate(opts)
+		}
+		results[i].duration = time.Since(start)
+		results[i].err = err
+	}
+	if !m.Parallel {
+		for i := range m.Targets {
+			run(i)
+		}
+		return results
+	}
+	var wg sync.WaitGroup
+	for i := range m.Targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(i)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func writeManifestReport(w io.Writer, results []targetResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tDATABASE\tRESULT\tAPPLIED\tDURATION\tERROR")
+	for _, r := range results {
+		result, message := "ok", "-"
+		if r.err != nil {
+			result, message = "failed", strings.ReplaceAll(r.err.Error(), "\n", " ")
+		}
+		applied := "-"
+		if len(r.completed) > 0 {
+			applied = strings.Join(r.completed, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.name, r.database, result, applied, r.duration.Round(time.Millisecond), message)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,120 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifest(t *testing.T) {
+	writeManifest := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "manifest.json")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("unable to write manifest: %s", err)
+		}
+		return path
+	}
+
+	t.Run("loadManifest should read targets and default their names", func(t *testing.T) {
+		path := writeManifest(t, `{
+			"parallel": true,
+			"targets": [
+				{"name": "app", "database": "app", "directory": "testdata", "lock_timeout": "5s", "out_of_order": "warn"},
+				{"database": "jobs", "directory": "testdata"}
+			]
+		}`)
+		m, err := loadManifest(path)
+		if err != nil {
+			t.Fatalf("unable to load manifest: %s", err)
+		}
+		if !m.Parallel || len(m.Targets) != 2 || m.Targets[0].Name != "app" || m.Targets[1].Name != "jobs" {
+			t.Errorf("unexpected manifest %v", m)
+		}
+
+		c := config{database: "postgres", migrationDir: "migrations", outOfOrder: PolicyFail, missing: PolicyFail, logger: slog.Default()}
+		tc, err := m.Targets[0].apply(c)
+		if err != nil {
+			t.Fatalf("unable to apply target: %s", err)
+		}
+		if tc.database != "app" || tc.migrationDir != "testdata" || tc.lockTimeout != 5*time.Second || tc.outOfOrder != PolicyWarn || tc.missing != PolicyFail {
+			t.Errorf("unexpected config %v", tc)
+		}
+		if c.database != "postgres" {
+			t.Errorf("expected the base config to be unchanged")
+		}
+	})
+
+	t.Run("loadManifest should reject invalid manifests", func(t *testing.T) {
+		for _, content := range []string{
+			`{"targets": []}`,
+			`{"targets": [{"database": "app"}]}`,
+			`{"targets": [{"database": "app", "directory": "does-not-exist"}]}`,
+			`{"targets": [{"database": "app", "directory": "testdata"}, {"database": "app", "directory": "testdata"}]}`,
+			`{"targets": [{"database": "app", "directory": "testdata", "unknown": true}]}`,
+		} {
+			if _, err := loadManifest(writeManifest(t, content)); err == nil {
+				t.Errorf("expected manifest %s to be invalid", content)
+			}
+		}
+	})
+
+	t.Run("apply should reject invalid target settings", func(t *testing.T) {
+		c := config{logger: slog.Default()}
+		if _, err := (manifestTarget{Name: "app", OutOfOrder: "sometimes"}).apply(c); err == nil {
+			t.Errorf("expected invalid policy to fail")
+		}
+		if _, err := (manifestTarget{Name: "app", StatementTimeout: "forever"}).apply(c); err == nil {
+			t.Errorf("expected invalid timeout to fail")
+		}
+	})
+
+	t.Run("runManifest should record a target with unreadable migrations as failed", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "001.sql"), []byte("-- pgmigrate: sometimes\nselect 1;\n"), 0o644); err != nil {
+			t.Fatalf("unable to write migration: %s", err)
+		}
+		m := manifest{Targets: []manifestTarget{
+			{Name: "app", Database: "app", Directory: dir},
+			{Name: "jobs", Database: "jobs", Directory: "does-not-exist"},
+		}}
+		c := config{outOfOrder: PolicyFail, missing: PolicyFail, logger: slog.Default()}
+		results := c.runManifest(m, nil)
+		if len(results) != 2 {
+			t.Fatalf("expected a result per target but got %v", results)
+		}
+		for _, r := range results {
+			if r.err == nil {
+				t.Errorf("expected target %s to fail", r.name)
+			}
+		}
+		if !strings.Contains(results[0].err.Error(), "invalid directive") {
+			t.Errorf("expected the invalid directive to be reported but got %v", results[0].err)
+		}
+	})
+
+	t.Run("writeManifestReport should print a row per target", func(t *testing.T) {
+		var buf bytes.Buffer
+		results := []targetResult{
+			{name: "app", database: "app", completed: []string{"001", "002"}, duration: 1500 * time.Millisecond},
+			{name: "jobs", database: "jobs", err: errors.New("boom")},
+		}
+		if err := writeManifestReport(&buf, results); err != nil {
+			t.Fatalf("unable to write report: %s", err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("expected header and 2 rows but got %q", buf.String())
+		}
+		if got := strings.Join(strings.Fields(lines[1]), " "); got != "app app ok 001,002 1.5s -" {
+			t.Errorf("unexpected row %q", got)
+		}
+		if got := strings.Join(strings.Fields(lines[2]), " "); got != "jobs jobs failed - 0s boom" {
+			t.Errorf("unexpected row %q", got)
+		}
+	})
+}
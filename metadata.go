@@ -0,0 +1,52 @@
+package pgmigrate
+
+import (
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+const modulePath = "github.com/emillamm/pgmigrate"
+
+// Version of pgmigrate that is recorded with every applied migration. It can be set at build time
+// with -ldflags "-X github.com/emillamm/pgmigrate.Version=v1.2.3" and otherwise defaults to the
+// module version found in the build info.
+var Version = ""
+
+func toolVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if info.Main.Path == modulePath && info.Main.Version != "" {
+			return info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				return dep.Version
+			}
+		}
+	}
+	return "unknown"
+}
+
+// executionMetadata describes where and how a migration was applied. The database user is read
+// from current_user when the migration is recorded.
+type executionMetadata struct {
+	hostname    string
+	toolVersion string
+	appVersion  string
+	duration    time.Duration
+}
+
+func newExecutionMetadata(o options) executionMetadata {
+	hostname, err := os.Hostname()
+	if err != nil {
+		o.logger.Warn("unable to determine hostname", logKeyError, err)
+	}
+	return executionMetadata{
+		hostname:    hostname,
+		toolVersion: toolVersion(),
+		appVersion:  o.appVersion,
+	}
+}
@@ -0,0 +1,79 @@
+package pgmigrate
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics collects Prometheus metrics about migration runs. A nil *Metrics records nothing.
+type Metrics struct {
+	applied  prometheus.Counter
+	failed   prometheus.Counter
+	duration prometheus.Histogram
+	pending  *prometheus.GaugeVec
+	stale    *prometheus.GaugeVec
+}
+
+// The pending and stale gauges are labelled with the database and schema of a run, so that runs
+// against several databases or schemas sharing the metrics don't overwrite each other. The schema
+// label is empty for runs without WithSchema.
+var gaugeLabels = []string{"database", "schema"}
+
+// NewMetrics creates the migration metrics and registers them on the given registerer.
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		applied: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pgmigrate_migrations_applied_total",
+			Help: "Number of migrations applied successfully.",
+		}),
+		failed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pgmigrate_migrations_failed_total",
+			Help: "Number of migrations that failed.",
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "pgmigrate_migration_duration_seconds",
+			Help:    "Duration of applying a single migration.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		}),
+		pending: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pgmigrate_migrations_pending",
+			Help: "Number of migrations waiting to be applied.",
+		}, gaugeLabels),
+		stale: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pgmigrate_migrations_stale",
+			Help: "Number of started migrations that did not complete within the retry interval.",
+		}, gaugeLabels),
+	}
+	for _, c := range []prometheus.Collector{m.applied, m.failed, m.duration, m.pending, m.stale} {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) observeMigration(labels prometheus.Labels, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.duration.Observe(duration.Seconds())
+	if err != nil {
+		m.failed.Inc()
+	} else {
+		m.applied.Inc()
+		m.pending.With(labels).Dec()
+	}
+}
+
+func (m *Metrics) setPending(labels prometheus.Labels, n int) {
+	if m != nil {
+		m.pending.With(labels).Set(float64(n))
+	}
+}
+
+func (m *Metrics) setStale(labels prometheus.Labels, n int) {
+	if m != nil {
+		m.stale.With(labels).Set(float64(n))
+	}
+}
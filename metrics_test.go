@@ -0,0 +1,105 @@
+package pgmigrate
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("NewMetrics should fail if the metrics are already registered", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+		if _, err := NewMetrics(registry); err != nil {
+			t.Fatalf("unable to create metrics: %s", err)
+		}
+		if _, err := NewMetrics(registry); err == nil {
+			t.Errorf("expected duplicate registration to fail")
+		}
+	})
+
+	t.Run("Metrics should count applied and failed migrations", func(t *testing.T) {
+		metrics, err := NewMetrics(prometheus.NewRegistry())
+		if err != nil {
+			t.Fatalf("unable to create metrics: %s", err)
+		}
+		labels := prometheus.Labels{"database": "app", "schema": ""}
+		metrics.setPending(labels, 2)
+		metrics.observeMigration(labels, time.Second, nil)
+		metrics.observeMigration(labels, time.Second, errors.New("failed"))
+		if got := testutil.ToFloat64(metrics.applied); got != 1 {
+			t.Errorf("got %f applied, wanted 1", got)
+		}
+		if got := testutil.ToFloat64(metrics.failed); got != 1 {
+			t.Errorf("got %f failed, wanted 1", got)
+		}
+		if got := testutil.ToFloat64(metrics.pending); got != 1 {
+			t.Errorf("got %f pending, wanted 1", got)
+		}
+	})
+
+	t.Run("Metrics should keep the gauges of different schemas apart", func(t *testing.T) {
+		metrics, err := NewMetrics(prometheus.NewRegistry())
+		if err != nil {
+			t.Fatalf("unable to create metrics: %s", err)
+		}
+		tenantA := prometheus.Labels{"database": "app", "schema": "tenant_a"}
+		tenantB := prometheus.Labels{"database": "app", "schema": "tenant_b"}
+		metrics.setPending(tenantA, 2)
+		metrics.setPending(tenantB, 3)
+		metrics.observeMigration(tenantA, time.Second, nil)
+		if got := testutil.ToFloat64(metrics.pending.With(tenantA)); got != 1 {
+			t.Errorf("got %f pending in tenant_a, wanted 1", got)
+		}
+		if got := testutil.ToFloat64(metrics.pending.With(tenantB)); got != 3 {
+			t.Errorf("got %f pending in tenant_b, wanted 3", got)
+		}
+	})
+
+	t.Run("A nil *Metrics should record nothing", func(t *testing.T) {
+		var metrics *Metrics
+		metrics.setPending(nil, 1)
+		metrics.setStale(nil, 1)
+		metrics.observeMigration(nil, time.Second, nil)
+	})
+
+	db, host, port := parentSession(t)
+
+	t.Run("RunMigrations should record metrics", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			metrics, err := NewMetrics(prometheus.NewRegistry())
+			if err != nil {
+				t.Fatalf("unable to create metrics: %s", err)
+			}
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"create table test_table2(id text)"}},
+				{Id: "003", Statements: []string{"invalid"}},
+			}
+			initMigrationsTable(newExecutor(session))
+			markAsStarted(newExecutor(session), "001", getCurrentTime(session).Add(-time.Hour))
+
+			if _, err := RunMigrations(session, migrations, 60, WithMetrics(metrics)); err == nil {
+				t.Errorf("expected migration 003 to fail")
+			}
+			if got := testutil.ToFloat64(metrics.applied); got != 2 {
+				t.Errorf("got %f applied, wanted 2", got)
+			}
+			if got := testutil.ToFloat64(metrics.failed); got != 1 {
+				t.Errorf("got %f failed, wanted 1", got)
+			}
+			if got := testutil.ToFloat64(metrics.pending); got != 1 {
+				t.Errorf("got %f pending, wanted 1", got)
+			}
+			if got := testutil.ToFloat64(metrics.stale); got != 1 {
+				t.Errorf("got %f stale, wanted 1", got)
+			}
+			if got := testutil.CollectAndCount(metrics.duration); got != 1 {
+				t.Errorf("got %d duration histograms, wanted 1", got)
+			}
+		})
+	})
+}
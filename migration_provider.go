@@ -2,16 +2,54 @@ package pgmigrate
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Migration struct {
 	Id         string
 	Statements []string
+	// Run again after all versioned migrations whenever the checksum changes
+	Repeatable bool
+	// Run the statements outside of a transaction. Migrations containing statements that can't run
+	// inside a transaction block, e.g. create index concurrently, are run this way automatically.
+	NoTransaction bool
+	// Override the lock_timeout and statement_timeout set for all migrations when non-zero
+	LockTimeout      time.Duration
+	StatementTimeout time.Duration
+	// Backfill a large table in batches instead of running statements. Migration files declare it
+	// with the batch-key directive, optionally batch-size and batch-pause, and a single update
+	// statement.
+	Batch *BatchUpdate
+	// Ids of the migrations that must be applied first. When empty, a migration depends on every
+	// earlier versioned migration that no other earlier migration depends on. Expand migrations only
+	// consider earlier expand migrations.
+	DependsOn []string
+	// Tag the migration for the expand or contract phase of a zero-downtime deploy. Untagged
+	// migrations belong to the expand phase.
+	Phase Phase
+	// Ids of the lint rules that are not checked for this migration
+	LintIgnore []string
+}
+
+// Checksum returns a hex encoded SHA-256 digest of the migration statements. The table, key and
+// expressions of a batch update are included, but not its size and pause.
+func (m Migration) Checksum() string {
+	h := sha256.New()
+	for _, s := range m.Statements {
+		fmt.Fprintln(h, s)
+	}
+	if b := m.Batch; b != nil {
+		fmt.Fprintln(h, b.Table, b.Key, b.Set, b.Where)
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 type MigrationProvider interface {
@@ -22,21 +60,77 @@ type FileMigrationProvider struct {
 	Directory string
 }
 
+// GetMigrations reads the migrations in the directory and exits if any of them can't be read.
 func (f *FileMigrationProvider) GetMigrations() []Migration {
+	migrations, err := f.ReadMigrations()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return migrations
+}
+
+// ReadMigrations reads the migrations in the directory, skipping SQL hook files.
+func (f *FileMigrationProvider) ReadMigrations() ([]Migration, error) {
 	files, err := os.ReadDir(f.Directory)
 	if err != nil {
-		log.Fatalf("unable to read files from '%s' folder: %v", f.Directory, err)
+		return nil, fmt.Errorf("unable to read files from '%s' folder: %v", f.Directory, err)
 	}
 	var migrations []Migration
 	for _, file := range files {
+		if _, isHook := hookFileNames[file.Name()]; isHook {
+			continue
+		}
 		if isValidFileName(file.Name()) {
-			migration := readMigrationFromFile(f.Directory, file.Name())
+			migration, err := readMigrationFromFile(f.Directory, file.Name())
+			if err != nil {
+				return nil, err
+			}
 			migrations = append(migrations, migration)
 		}
 	}
-	return migrations
+	return migrations, nil
+}
+
+// hookFileNames maps files in the migration directory to the SQL hook they define
+var hookFileNames = map[string]func(h *SQLHooks) *[]string{
+	"beforeMigrate.sql":     func(h *SQLHooks) *[]string { return &h.BeforeAll },
+	"beforeEachMigrate.sql": func(h *SQLHooks) *[]string { return &h.BeforeEach },
+	"afterEachMigrate.sql":  func(h *SQLHooks) *[]string { return &h.AfterEach },
+	"afterMigrate.sql":      func(h *SQLHooks) *[]string { return &h.AfterAll },
+}
+
+// GetSQLHooks reads the SQL hooks in the directory and exits if any of them can't be read.
+func (f *FileMigrationProvider) GetSQLHooks() SQLHooks {
+	hooks, err := f.ReadSQLHooks()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return hooks
+}
+
+// ReadSQLHooks reads the statements of beforeMigrate.sql, beforeEachMigrate.sql, afterEachMigrate.sql
+// and afterMigrate.sql in the migration directory if they exist.
+func (f *FileMigrationProvider) ReadSQLHooks() (SQLHooks, error) {
+	var hooks SQLHooks
+	for fileName, hook := range hookFileNames {
+		if _, err := os.Stat(fmt.Sprintf("%s/%s", f.Directory, fileName)); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return hooks, fmt.Errorf("unable to read hook file '%s': %v", fileName, err)
+		}
+		m, err := readMigrationFromFile(f.Directory, fileName)
+		if err != nil {
+			return hooks, err
+		}
+		*hook(&hooks) = m.Statements
+	}
+	return hooks, nil
 }
 
+// repeatablePrefix marks files holding repeatable migrations, e.g. R__views.sql
+const repeatablePrefix = "R__"
+
 const validFileName = ".+\\.sql"
 
 func isValidFileName(fileName string) bool {
@@ -92,17 +186,74 @@ func stripComments(line string) string {
 	return strings.TrimSpace(result.String())
 }
 
-func readMigrationFromFile(filePath string, fileName string) Migration {
+// directivePrefix marks a comment line holding space separated directives that configure the
+// migration, e.g. "-- pgmigrate: no-transaction lock-timeout=5s"
+const directivePrefix = "-- pgmigrate:"
+
+func parseDirectives(migration *Migration, directives string) (err error) {
+	for _, directive := range strings.Fields(directives) {
+		key, value, _ := strings.Cut(directive, "=")
+		switch key {
+		case "no-transaction":
+			migration.NoTransaction = true
+		case "lock-timeout":
+			migration.LockTimeout, err = time.ParseDuration(value)
+		case "statement-timeout":
+			migration.StatementTimeout, err = time.ParseDuration(value)
+		case "depends-on":
+			if value == "" {
+				err = fmt.Errorf("depends-on requires migration ids")
+			} else {
+				migration.DependsOn = append(migration.DependsOn, strings.Split(value, ",")...)
+			}
+		case "lint-ignore":
+			for _, id := range strings.Split(value, ",") {
+				if !isLintRule(id) {
+					return fmt.Errorf("unknown lint rule %s", id)
+				}
+				migration.LintIgnore = append(migration.LintIgnore, id)
+			}
+		case "batch-key":
+			migration.Batch = batchOf(migration)
+			migration.Batch.Key = value
+		case "batch-size":
+			migration.Batch = batchOf(migration)
+			migration.Batch.Size, err = strconv.ParseInt(value, 10, 64)
+		case "batch-pause":
+			migration.Batch = batchOf(migration)
+			migration.Batch.Pause, err = time.ParseDuration(value)
+		case "phase":
+			migration.Phase, err = ParsePhase(value)
+		default:
+			err = fmt.Errorf("unknown directive %s", key)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func batchOf(migration *Migration) *BatchUpdate {
+	if migration.Batch == nil {
+		return &BatchUpdate{}
+	}
+	return migration.Batch
+}
+
+func readMigrationFromFile(filePath string, fileName string) (Migration, error) {
 	fullPath := fmt.Sprintf("%s/%s", filePath, fileName)
 	file, err := os.Open(fullPath)
 	if err != nil {
-		log.Fatal(err)
+		return Migration{}, err
 	}
 	defer file.Close()
 
+	id := strings.Split(fileName, ".")[0]
+	migration := Migration{Id: id, Repeatable: strings.HasPrefix(fileName, repeatablePrefix)}
+
 	// scan through lines and concatenate lines that don't end with ';' as statements
 	scanner := bufio.NewScanner(file)
-	var statements []string
 	var statement strings.Builder
 	var whitespace string
 	for scanner.Scan() {
@@ -110,6 +261,12 @@ func readMigrationFromFile(filePath string, fileName string) Migration {
 		if line == "" {
 			continue
 		}
+		if directives, ok := strings.CutPrefix(line, directivePrefix); ok {
+			if err := parseDirectives(&migration, directives); err != nil {
+				return migration, fmt.Errorf("invalid directive in %s: %v", fullPath, err)
+			}
+			continue
+		}
 		// Strip comments (both full-line and inline)
 		line = stripComments(line)
 		if line == "" {
@@ -118,11 +275,15 @@ func readMigrationFromFile(filePath string, fileName string) Migration {
 		fmt.Fprintf(&statement, "%s%s", whitespace, line)
 		whitespace = " "
 		if line[len(line)-1] == ';' {
-			statements = append(statements, statement.String())
+			migration.Statements = append(migration.Statements, statement.String())
 			statement.Reset()
 			whitespace = ""
 		}
 	}
-	id := strings.Split(fileName, ".")[0]
-	return Migration{Id: id, Statements: statements}
+	if migration.Batch != nil {
+		if err := parseBatchUpdate(&migration); err != nil {
+			return migration, fmt.Errorf("invalid batch update in %s: %v", fullPath, err)
+		}
+	}
+	return migration, scanner.Err()
 }
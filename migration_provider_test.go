@@ -1,8 +1,11 @@
 package pgmigrate
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestStripComments(t *testing.T) {
@@ -79,13 +82,6 @@ func TestMigrationProvider(t *testing.T) {
 	t.Run("read migrations from files", func(t *testing.T) {
 		got := provider.GetMigrations()
 		want := []Migration{
-			{
-				Id: "000",
-				Statements: []string{
-					"create role test_user;",
-					"create database test_database;",
-				},
-			},
 			{
 				Id: "001",
 				Statements: []string{
@@ -100,10 +96,166 @@ func TestMigrationProvider(t *testing.T) {
 					"create table sofas (brand varchar(255));",
 				},
 			},
+			{
+				Id: "003",
+				Statements: []string{
+					"create index concurrently cars_brand_idx on cars (brand);",
+				},
+				NoTransaction:    true,
+				LockTimeout:      5 * time.Second,
+				StatementTimeout: time.Minute,
+			},
+			{
+				Id: "R__views",
+				Statements: []string{
+					"create or replace view car_brands as select brand from cars;",
+				},
+				Repeatable: true,
+			},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("read cluster migrations from files", func(t *testing.T) {
+		got := (&FileMigrationProvider{"testdata/cluster"}).GetMigrations()
+		want := []Migration{
+			{
+				Id: "000",
+				Statements: []string{
+					"create role test_user;",
+					"create database test_database;",
+				},
+			},
 		}
 
 		if !reflect.DeepEqual(got, want) {
-			t.Errorf("got %q, want %q", got, want)
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("ReadMigrations should return an error for a directory that doesn't exist", func(t *testing.T) {
+		if _, err := (&FileMigrationProvider{"does-not-exist"}).ReadMigrations(); err == nil {
+			t.Errorf("expected an error for a missing directory")
 		}
 	})
+
+	t.Run("ReadMigrations and ReadSQLHooks should return an error for an invalid directive", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, name := range []string{"001.sql", "afterMigrate.sql"} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("-- pgmigrate: sometimes\nselect 1;\n"), 0o644); err != nil {
+				t.Fatalf("unable to write migration: %s", err)
+			}
+		}
+		invalid := &FileMigrationProvider{dir}
+		if _, err := invalid.ReadMigrations(); err == nil {
+			t.Errorf("expected an error for an invalid directive in a migration")
+		}
+		if _, err := invalid.ReadSQLHooks(); err == nil {
+			t.Errorf("expected an error for an invalid directive in a hook")
+		}
+	})
+
+	t.Run("read SQL hooks from files", func(t *testing.T) {
+		got := provider.GetSQLHooks()
+		want := SQLHooks{
+			AfterAll: []string{
+				"grant select on all tables in schema public to test_user;",
+			},
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestParseDirectives(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Migration
+		wantErr bool
+	}{
+		{
+			name:  "no directives",
+			input: "",
+			want:  Migration{},
+		},
+		{
+			name:  "no-transaction",
+			input: " no-transaction",
+			want:  Migration{NoTransaction: true},
+		},
+		{
+			name:  "timeouts",
+			input: " lock-timeout=500ms statement-timeout=10m",
+			want:  Migration{LockTimeout: 500 * time.Millisecond, StatementTimeout: 10 * time.Minute},
+		},
+		{
+			name:  "depends-on",
+			input: " depends-on=001,002",
+			want:  Migration{DependsOn: []string{"001", "002"}},
+		},
+		{
+			name:    "depends-on without ids",
+			input:   " depends-on=",
+			wantErr: true,
+		},
+		{
+			name:  "batch update",
+			input: " batch-key=id batch-size=500 batch-pause=1s",
+			want:  Migration{Batch: &BatchUpdate{Key: "id", Size: 500, Pause: time.Second}},
+		},
+		{
+			name:    "invalid batch size",
+			input:   " batch-key=id batch-size=many",
+			wantErr: true,
+		},
+		{
+			name:  "phase",
+			input: " phase=contract",
+			want:  Migration{Phase: PhaseContract},
+		},
+		{
+			name:    "invalid phase",
+			input:   " phase=cleanup",
+			wantErr: true,
+		},
+		{
+			name:  "lint-ignore",
+			input: " lint-ignore=PGM001,PGM004",
+			want:  Migration{LintIgnore: []string{"PGM001", "PGM004"}},
+		},
+		{
+			name:    "unknown lint rule",
+			input:   " lint-ignore=PGM999",
+			wantErr: true,
+		},
+		{
+			name:    "invalid duration",
+			input:   " lock-timeout=soon",
+			wantErr: true,
+		},
+		{
+			name:    "unknown directive",
+			input:   " transaction",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Migration
+			err := parseDirectives(&got, tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDirectives() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDirectives() = %v, want %v", got, tt.want)
+			}
+		})
+	}
 }
@@ -1,18 +1,74 @@
 package pgmigrate
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
-	"log"
 	"slices"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func RunMigrations(
-	session *sql.DB,
+func RunMigrations[S Session](
+	session S,
+	migrations []Migration,
+	retryAfterSeconds int,
+	opts ...Option,
+) (completed []string, err error) {
+	return RunMigrationsContext(context.Background(), session, migrations, retryAfterSeconds, opts...)
+}
+
+func RunMigrationsContext[S Session](
+	ctx context.Context,
+	session S,
+	migrations []Migration,
+	retryAfterSeconds int,
+	opts ...Option,
+) (completed []string, err error) {
+	return runMigrations(ctx, newExecutor(session), migrations, retryAfterSeconds, newOptions(opts))
+}
+
+func runMigrations(
+	ctx context.Context,
+	session executor,
 	migrations []Migration,
 	retryAfterSeconds int,
+	o options,
 ) (completed []string, err error) {
+	ctx, span := o.tracer.Start(ctx, "pgmigrate.run")
+	defer func() {
+		span.SetAttributes(attrCompleted.Int(len(completed)))
+		endSpan(span, err)
+	}()
+
+	if err = validateBatchUpdates(migrations); err != nil {
+		return
+	}
+	dependencies, err := getDependencies(migrations)
+	if err != nil {
+		return
+	}
+	pool := session
+
+	// Everything runs on one connection so session state such as search_path, roles and temporary
+	// tables carries over between statements, migrations and hooks
+	conn, releaseConn, err := acquireConn(ctx, session, o.schema)
+	if err != nil {
+		return
+	}
+	defer releaseConn()
+	session = conn
+
+	release, err := acquireLock(ctx, session, schemaLockId(o.schema), o.logger)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	if err = createSchema(session, o.schema); err != nil {
+		return
+	}
 	if err = initMigrationsTable(session); err != nil {
 		err = fmt.Errorf("failed to create migrations table: %v", err)
 		return
@@ -23,9 +79,26 @@ func RunMigrations(
 		err = fmt.Errorf("failed to read migrations: %v", err)
 		return
 	}
+	pending := inPhase(getPendingMigrations(records, migrations, dependencies), o.phase)
+	if o.metrics != nil {
+		var database string
+		if err = session.QueryRow(ctx, "select current_database();").Scan(&database); err != nil {
+			err = fmt.Errorf("failed to get current database: %v", err)
+			return
+		}
+		o.metricLabels = prometheus.Labels{"database": database, "schema": o.schema}
+	}
+	o.metrics.setPending(o.metricLabels, len(pending))
 
-	if startedRecords, latest := getStartedRecords(records); len(startedRecords) > 0 {
-		secondsSinceLatest := getCurrentTime(session).Sub(*latest.startedAt).Seconds()
+	startedRecords, latest := getStartedRecords(records)
+	o.metrics.setStale(o.metricLabels, 0)
+	if len(startedRecords) > 0 {
+		var now time.Time
+		if now, err = currentTime(session); err != nil {
+			return
+		}
+		o.metrics.setStale(o.metricLabels, countStale(startedRecords, now, retryAfterSeconds))
+		secondsSinceLatest := now.Sub(*latest.startedAt).Seconds()
 		if retryAfterSeconds < 0 || float64(retryAfterSeconds) > secondsSinceLatest {
 			var ids []string
 			for _, r := range startedRecords {
@@ -36,28 +109,167 @@ func RunMigrations(
 		}
 	}
 
-	for _, m := range migrations {
-		if isCompleted(records, m.Id) {
-			continue
+	if missing := getMissingMigrations(records, migrations); len(missing) > 0 {
+		switch o.missing {
+		case PolicyFail:
+			err = MissingMigrationsError{Ids: missing}
+			return
+		case PolicyWarn:
+			o.logger.Warn("applied migrations are missing from the provided migrations", logKeyMigrationIds, missing)
 		}
-		markAsStarted(session, m.Id, getCurrentTime(session))
-		for i, s := range m.Statements {
-			if _, err = session.Exec(s); err != nil {
-				err = fmt.Errorf("failed to process statement %d in migration %s: %s", i, m.Id, err)
+	}
+
+	if outOfOrder, latest := getOutOfOrderMigrations(records, versionedMigrations(migrations), dependencies); len(outOfOrder) > 0 {
+		switch o.outOfOrder {
+		case PolicyFail:
+			err = OutOfOrderMigrationsError{Ids: outOfOrder, LatestApplied: latest}
+			return
+		case PolicyWarn:
+			o.logger.Warn("applying migrations out of order", logKeyMigrationIds, outOfOrder, logKeyLatestApplied, latest)
+		}
+	}
+
+	for _, m := range pending {
+		if m.phase() == PhaseContract {
+			if expandIds := getUnappliedExpandMigrations(records, migrations, pending, dependencies, m); len(expandIds) > 0 {
+				err = ExpandNotAppliedError{Id: m.Id, ExpandIds: expandIds}
 				return
 			}
 		}
-		markAsCompleted(session, m.Id, getCurrentTime(session))
-		completed = append(completed, m.Id)
+		// Dependencies left out by the phase would otherwise be treated as applied
+		if id, ok := getUnappliedDependency(records, pending, dependencies, m); ok {
+			err = UnappliedDependencyError{Id: m.Id, DependencyId: id, Phase: phaseOf(migrations, id)}
+			return
+		}
+	}
+
+	if err = o.hooks.BeforeAll(); err != nil {
+		o.logger.Error("hook failed", logKeyHook, "before all", logKeyError, err)
+		err = fmt.Errorf("before all hook failed: %v", err)
+		return
+	}
+	if err = execHookStatements(session, o.logger, "before all", o.sqlHooks.BeforeAll); err != nil {
+		return
+	}
+	defer func() {
+		if err == nil {
+			err = execHookStatements(session, o.logger, "after all", o.sqlHooks.AfterAll)
+		}
+		o.hooks.AfterAll(completed, err)
+		if err != nil {
+			o.logger.Error("migration run failed", logKeyMigrationIds, completed, logKeyError, err)
+		} else {
+			o.logger.Info("migration run completed", logKeyMigrationIds, completed)
+		}
+	}()
+
+	span.SetAttributes(attrPending.Int(len(pending)))
+	if parallelism := maxParallelism(pool, o.parallelism); parallelism < o.parallelism && pool.Pooled() {
+		o.logger.Warn("limiting parallelism to the connections available in the pool", logKeyParallelism, parallelism)
+		o.parallelism = parallelism
+	}
+	if o.parallelism > 1 && pool.Pooled() {
+		versioned := versionedMigrations(pending)
+		if completed, err = runConcurrently(ctx, pool, versioned, dependencies, o); err != nil {
+			return
+		}
+		pending = pending[len(versioned):]
+	}
+	for _, m := range pending {
+		var applied bool
+		applied, err = runMigrationWithHooks(ctx, session, m, o)
+		if applied {
+			completed = append(completed, m.Id)
+		}
+		if err != nil {
+			return
+		}
 	}
 	return
 }
 
-func initMigrationsTable(session *sql.DB) error {
-	query := fmt.Sprintf("create table if not exists migrations(id varchar(255) primary key, started_at timestamptz, completed_at timestamptz);")
-	if _, err := session.Exec(query); err != nil {
+// runMigrationWithHooks runs a migration between the before each and after each hooks and reports
+// whether the migration was applied. The migration is committed before the after each SQL hook runs,
+// so a failing after each hook is returned as an error without counting the migration as failed.
+func runMigrationWithHooks(ctx context.Context, session executor, m Migration, o options) (applied bool, err error) {
+	ctx, span := o.tracer.Start(ctx, "pgmigrate.migration", trace.WithAttributes(attrMigrationId.String(m.Id)))
+	defer func() { endSpan(span, err) }()
+
+	if err = o.hooks.BeforeEach(m); err != nil {
+		o.logger.Error("hook failed", logKeyHook, "before each", logKeyMigrationId, m.Id, logKeyError, err)
+		return false, fmt.Errorf("before each hook failed for migration %s: %v", m.Id, err)
+	}
+	if err = execHookStatements(session, o.logger, "before each", o.sqlHooks.BeforeEach); err != nil {
+		return
+	}
+	o.logger.Info("migration started", logKeyMigrationId, m.Id)
+	start := time.Now()
+	err = runMigration(ctx, session, m, o)
+	duration := time.Since(start)
+	o.hooks.AfterEach(m, duration, err)
+	o.metrics.observeMigration(o.metricLabels, duration, err)
+	if err != nil {
+		o.logger.Error("migration failed", logKeyMigrationId, m.Id, logKeyDuration, duration, logKeyError, err)
+		return
+	}
+	o.logger.Info("migration completed", logKeyMigrationId, m.Id, logKeyDuration, duration)
+	if err = execHookStatements(session, o.logger, "after each", o.sqlHooks.AfterEach); err != nil {
+		return true, fmt.Errorf("migration %s was applied but the after each hook failed: %v", m.Id, err)
+	}
+	return true, nil
+}
+
+func runMigration(ctx context.Context, session executor, m Migration, o options) error {
+	startedAt, err := currentTime(session)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	metadata := newExecutionMetadata(o)
+	if err := applyMigration(ctx, session, m, startedAt, o); err != nil {
+		if historyErr := recordHistory(session, m.Id, OperationApply, startedAt, metadata, err); historyErr != nil {
+			o.logger.Warn("unable to record failed migration in history", logKeyMigrationId, m.Id, logKeyError, historyErr)
+		}
+		return err
+	}
+	metadata.duration = time.Since(start)
+	completedAt, err := currentTime(session)
+	if err != nil {
 		return err
 	}
+	queries := []batchQuery{
+		markAsCompletedQuery(m.Id, completedAt),
+		executionMetadataQuery(m.Id, metadata),
+		checksumQuery(m.Id, m.Checksum()),
+		phaseQuery(m.Id, m.phase()),
+		historyQuery(m.Id, OperationApply, startedAt, metadata, nil),
+	}
+	if err := session.SendBatch(ctx, queries); err != nil {
+		return fmt.Errorf("failed to mark migration %s as completed: %s", m.Id, err)
+	}
+	return nil
+}
+
+func initMigrationsTable(session querier) error {
+	queries := []string{
+		"create table if not exists migrations(id varchar(255) primary key, started_at timestamptz, completed_at timestamptz);",
+		"alter table migrations add column if not exists baseline boolean not null default false;",
+		"alter table migrations add column if not exists checksum varchar(64);",
+		"alter table migrations add column if not exists applied_by text;",
+		"alter table migrations add column if not exists hostname text;",
+		"alter table migrations add column if not exists tool_version text;",
+		"alter table migrations add column if not exists app_version text;",
+		"alter table migrations add column if not exists duration_ms bigint;",
+		"alter table migrations add column if not exists phase text;",
+		createHistoryTable,
+		createBatchProgressTable,
+		createStatementProgressTable,
+	}
+	for _, query := range queries {
+		if err := session.Exec(context.Background(), query); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -81,63 +293,175 @@ type record struct {
 	id          string
 	startedAt   *time.Time
 	completedAt *time.Time
+	baseline    bool
+	checksum    *string
+	appliedBy   *string
+	hostname    *string
+	toolVersion *string
+	appVersion  *string
+	durationMs  *int64
+	phase       *string
 }
 
-func getAllRecords(session *sql.DB) (migrations []record, err error) {
-	q := "select id, started_at, completed_at from migrations"
-	rows, err := session.Query(q)
+func getAllRecords(session querier) (migrations []record, err error) {
+	q := "select id, started_at, completed_at, baseline, checksum, applied_by, hostname, tool_version, app_version, duration_ms, phase from migrations order by id"
+	rows, err := session.Query(context.Background(), q)
 	if err != nil {
 		err = fmt.Errorf("failed to get in progress rows: %s", err)
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var (
-			id          string
-			startedAt   *time.Time
-			completedAt *time.Time
-		)
-		if err = rows.Scan(&id, &startedAt, &completedAt); err != nil {
+		var r record
+		if err = rows.Scan(&r.id, &r.startedAt, &r.completedAt, &r.baseline, &r.checksum, &r.appliedBy, &r.hostname, &r.toolVersion, &r.appVersion, &r.durationMs, &r.phase); err != nil {
 			err = fmt.Errorf("failed to scan rows in migration table: %s", err)
 			return
 		}
-		migrations = append(migrations, record{
-			id:          id,
-			startedAt:   startedAt,
-			completedAt: completedAt,
-		})
+		migrations = append(migrations, r)
 	}
 	err = rows.Err()
 	return
 }
 
+func countStale(startedRecords []record, now time.Time, retryAfterSeconds int) (n int) {
+	for _, r := range startedRecords {
+		if recordStatus(r, now, retryAfterSeconds).State == StateStale {
+			n++
+		}
+	}
+	return
+}
+
+// getPendingMigrations returns the versioned migrations that haven't been completed, ordered by their
+// dependencies and otherwise in the order they were provided, followed by the repeatable migrations
+// whose checksums differ from the last run.
+func getPendingMigrations(records []record, migrations []Migration, dependencies map[string][]string) []Migration {
+	var pending, repeatable []Migration
+	for _, m := range migrations {
+		if m.Repeatable {
+			if !isCurrent(records, m) {
+				repeatable = append(repeatable, m)
+			}
+		} else if !isCompleted(records, m.Id) {
+			pending = append(pending, m)
+		}
+	}
+	// The dependencies were validated already
+	pending, _ = sortByDependencies(pending, dependencies)
+	return append(pending, repeatable...)
+}
+
+func versionedMigrations(migrations []Migration) (versioned []Migration) {
+	for _, m := range migrations {
+		if !m.Repeatable {
+			versioned = append(versioned, m)
+		}
+	}
+	return
+}
+
+// getOutOfOrderMigrations returns the pending migrations that applied migrations depend on, directly
+// or through other migrations, along with the id of the latest such applied migration. Unless
+// dependencies are declared, these are the pending migrations ordered before the latest applied one.
+func getOutOfOrderMigrations(records []record, migrations []Migration, dependencies map[string][]string) (ids []string, latestApplied string) {
+	for _, m := range migrations {
+		if !isCompleted(records, m.Id) {
+			continue
+		}
+		visited := map[string]bool{}
+		queue := slices.Clone(dependencies[m.Id])
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			if !isCompleted(records, id) {
+				latestApplied = m.Id
+				if !slices.Contains(ids, id) {
+					ids = append(ids, id)
+				}
+			}
+			queue = append(queue, dependencies[id]...)
+		}
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		return slices.IndexFunc(migrations, func(m Migration) bool { return m.Id == a }) -
+			slices.IndexFunc(migrations, func(m Migration) bool { return m.Id == b })
+	})
+	return
+}
+
+// getMissingMigrations returns the ids of recorded migrations that are not among the provided migrations.
+func getMissingMigrations(records []record, migrations []Migration) (ids []string) {
+	for _, r := range records {
+		if !slices.ContainsFunc(migrations, func(m Migration) bool { return m.Id == r.id }) {
+			ids = append(ids, r.id)
+		}
+	}
+	slices.Sort(ids)
+	return
+}
+
 func isCompleted(records []record, id string) bool {
 	return slices.ContainsFunc(records, func(r record) bool {
 		return r.id == id && r.completedAt != nil
 	})
 }
 
-func markAsStarted(session *sql.DB, migrationId string, currentTime time.Time) {
-	q := "insert into migrations (id, started_at) values ($1, $2) on conflict (id) do update set id = excluded.id, started_at = excluded.started_at;"
-	if _, err := session.Exec(q, migrationId, currentTime); err != nil {
-		log.Fatalf("failed to mark migration %s as processed: %s", migrationId, err)
+// isCurrent reports whether the migration has been completed with its current checksum
+func isCurrent(records []record, m Migration) bool {
+	checksum := m.Checksum()
+	return slices.ContainsFunc(records, func(r record) bool {
+		return r.id == m.Id && r.completedAt != nil && r.checksum != nil && *r.checksum == checksum
+	})
+}
+
+func markAsStarted(session querier, migrationId string, currentTime time.Time) error {
+	q := "insert into migrations (id, started_at) values ($1, $2) on conflict (id) do update set id = excluded.id, started_at = excluded.started_at, completed_at = null;"
+	if err := session.Exec(context.Background(), q, migrationId, currentTime); err != nil {
+		return fmt.Errorf("failed to mark migration %s as processed: %s", migrationId, err)
+	}
+	return nil
+}
+
+func markAsCompleted(session querier, migrationId string, currentTime time.Time) error {
+	bq := markAsCompletedQuery(migrationId, currentTime)
+	if err := session.Exec(context.Background(), bq.query, bq.args...); err != nil {
+		return fmt.Errorf("failed to mark migration %s as completed: %s", migrationId, err)
 	}
+	return nil
 }
 
-func markAsCompleted(session *sql.DB, migrationId string, currentTime time.Time) {
+func markAsCompletedQuery(migrationId string, currentTime time.Time) batchQuery {
 	q := "insert into migrations (id, completed_at) values ($1, $2) on conflict (id) do update set id = excluded.id, completed_at = excluded.completed_at;"
-	if _, err := session.Exec(q, migrationId, currentTime); err != nil {
-		log.Fatalf("failed to mark migration %s as completed: %s", migrationId, err)
+	return batchQuery{q, []any{migrationId, currentTime}}
+}
+
+func updateChecksum(session querier, migrationId string, checksum string) error {
+	bq := checksumQuery(migrationId, checksum)
+	if err := session.Exec(context.Background(), bq.query, bq.args...); err != nil {
+		return fmt.Errorf("failed to update checksum of migration %s: %s", migrationId, err)
 	}
+	return nil
+}
+
+func checksumQuery(migrationId string, checksum string) batchQuery {
+	return batchQuery{"update migrations set checksum = $2 where id = $1;", []any{migrationId, checksum}}
+}
+
+func executionMetadataQuery(migrationId string, metadata executionMetadata) batchQuery {
+	q := "update migrations set applied_by = current_user, hostname = $2, tool_version = $3, app_version = nullif($4, ''), duration_ms = $5 where id = $1;"
+	return batchQuery{q, []any{migrationId, metadata.hostname, metadata.toolVersion, metadata.appVersion, metadata.duration.Milliseconds()}}
 }
 
-func getCurrentTime(session *sql.DB) time.Time {
-	var ts time.Time
-	row := session.QueryRow("select current_timestamp;")
-	if err := row.Scan(&ts); err != nil {
-		log.Fatal("failed to read timestamp from database")
+func currentTime(session querier) (ts time.Time, err error) {
+	row := session.QueryRow(context.Background(), "select current_timestamp;")
+	if err = row.Scan(&ts); err != nil {
+		err = fmt.Errorf("failed to read timestamp from database: %s", err)
 	}
-	return ts
+	return
 }
 
 type InProgressMigrationsError struct {
@@ -148,3 +472,20 @@ type InProgressMigrationsError struct {
 func (e InProgressMigrationsError) Error() string {
 	return fmt.Sprintf("migrations with ids %v are in progress with the most recent started %f seconds ago", e.Ids, e.SecondsSinceLatest)
 }
+
+type OutOfOrderMigrationsError struct {
+	Ids           []string
+	LatestApplied string
+}
+
+func (e OutOfOrderMigrationsError) Error() string {
+	return fmt.Sprintf("migrations with ids %v are pending but ordered before the latest applied migration %s", e.Ids, e.LatestApplied)
+}
+
+type MissingMigrationsError struct {
+	Ids []string
+}
+
+func (e MissingMigrationsError) Error() string {
+	return fmt.Sprintf("migrations with ids %v are recorded in the database but missing from the provided migrations", e.Ids)
+}
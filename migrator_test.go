@@ -13,25 +13,7 @@ import (
 )
 
 func TestMigrate(t *testing.T) {
-	user := env.GetenvWithDefault("POSTGRES_USER", "postgres")
-	password := env.GetenvWithDefault("POSTGRES_PASSWORD", "postgres")
-	host := env.GetenvWithDefault("POSTGRES_HOST", "localhost")
-	portStr := env.GetenvWithDefault("POSTGRES_PORT", "5432")
-	port, err := strconv.Atoi(portStr)
-	if err != nil {
-		t.Errorf("invalid PORT %s", portStr)
-		return
-	}
-	database := env.GetenvWithDefault("POSTGRES_DATABASE", "postgres")
-
-	// Set up parent connection
-	connStr := fmt.Sprintf("user=%s password=%s host=%s port=%d database=%s sslmode=disable", user, password, host, port, database)
-	db, err := openConnection(connStr)
-	if err != nil {
-		t.Errorf("failed to open connection %s", err)
-		return
-	}
-	defer db.Close()
+	db, host, port := parentSession(t)
 
 	t.Run("RunMigrations should create a migration table if it doesn't exist", func(t *testing.T) {
 		ephemeralSession(t, db, host, port, func(session *sql.DB) {
@@ -104,10 +86,10 @@ func TestMigrate(t *testing.T) {
 				},
 			}
 
-			initMigrationsTable(session)
-			markAsCompleted(session, "001", getCurrentTime(session))
-			markAsStarted(session, "002", getCurrentTime(session))
-			markAsStarted(session, "003", getCurrentTime(session))
+			initMigrationsTable(newExecutor(session))
+			markAsCompleted(newExecutor(session), "001", getCurrentTime(session))
+			markAsStarted(newExecutor(session), "002", getCurrentTime(session))
+			markAsStarted(newExecutor(session), "003", getCurrentTime(session))
 
 			completed, err := RunMigrations(session, migrations, -1)
 			startedErr, ok := err.(InProgressMigrationsError)
@@ -140,9 +122,9 @@ func TestMigrate(t *testing.T) {
 					},
 				},
 			}
-			initMigrationsTable(session)
+			initMigrationsTable(newExecutor(session))
 			startedAt := getCurrentTime(session).Add(-10 * time.Second)
-			markAsStarted(session, "001", startedAt)
+			markAsStarted(newExecutor(session), "001", startedAt)
 			completed, err := RunMigrations(session, migrations, 5)
 			if err != nil {
 				t.Errorf("failed to ignore in-progress migration: %v", err)
@@ -158,7 +140,7 @@ func TestMigrate(t *testing.T) {
 			// Helper to verify inserted records
 			verifyRecords := func(expectedNumberOfRecords int) {
 				// Verify number or records
-				allRecords, err := getAllRecords(session)
+				allRecords, err := getAllRecords(newExecutor(session))
 				if err != nil {
 					t.Errorf("unable to get migration records")
 				}
@@ -171,6 +153,9 @@ func TestMigrate(t *testing.T) {
 					if r.id != fmt.Sprintf("00%d", i+1) || r.startedAt == nil || r.completedAt == nil {
 						t.Errorf("got %v, wanted id=00%d, startedAt!=nil, completedAt!=nil", r, i+1)
 					}
+					if r.appliedBy == nil || r.hostname == nil || r.toolVersion == nil || r.durationMs == nil {
+						t.Errorf("got %v, wanted execution metadata to be recorded", r)
+					}
 				}
 			}
 
@@ -226,10 +211,317 @@ func TestMigrate(t *testing.T) {
 			verifyRecords(3)
 		})
 	})
+
+	t.Run("RunMigrations should fail on pending migrations ordered before the latest applied migration", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{
+					Id: "001",
+					Statements: []string{
+						"create table test_table1(id text)",
+					},
+				},
+				{
+					Id: "002",
+					Statements: []string{
+						"create table test_table2(id text)",
+					},
+				},
+				{
+					Id: "003",
+					Statements: []string{
+						"create table test_table3(id text)",
+					},
+				},
+			}
+			if _, err := RunMigrations(session, []Migration{migrations[0], migrations[2]}, -1); err != nil {
+				t.Errorf("unable to run migrations: %s", err)
+			}
+
+			completed, err := RunMigrations(session, migrations, -1)
+			outOfOrderErr, ok := err.(OutOfOrderMigrationsError)
+			if !ok {
+				t.Errorf("expected OutOfOrderMigrationsError but got %v", err)
+				return
+			}
+			if len(outOfOrderErr.Ids) != 1 || outOfOrderErr.Ids[0] != "002" || outOfOrderErr.LatestApplied != "003" {
+				t.Errorf("OutOfOrderMigrationsError did not contain expected migration 002 and latest 003: %v", err)
+			}
+			if len(completed) > 0 {
+				t.Errorf("expected no completed migrations")
+			}
+			verifyTableExistence(t, session, "test_table2", false)
+		})
+	})
+
+	t.Run("RunMigrations should apply out-of-order migrations when the policy is warn or allow", func(t *testing.T) {
+		for _, policy := range []Policy{PolicyWarn, PolicyAllow} {
+			ephemeralSession(t, db, host, port, func(session *sql.DB) {
+				migrations := []Migration{
+					{
+						Id: "001",
+						Statements: []string{
+							"create table test_table1(id text)",
+						},
+					},
+					{
+						Id: "002",
+						Statements: []string{
+							"create table test_table2(id text)",
+						},
+					},
+				}
+				if _, err := RunMigrations(session, migrations[1:], -1); err != nil {
+					t.Errorf("unable to run migrations: %s", err)
+				}
+				completed, err := RunMigrations(session, migrations, -1, WithOutOfOrder(policy))
+				if err != nil {
+					t.Errorf("failed to run out-of-order migrations with policy %s: %v", policy, err)
+				}
+				if len(completed) != 1 || completed[0] != "001" {
+					t.Errorf("expected migration 001 to be completed with policy %s but got %v", policy, completed)
+				}
+			})
+		}
+	})
+
+	t.Run("RunMigrations should fail if applied migrations are missing from the provided migrations", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{
+					Id: "001",
+					Statements: []string{
+						"create table test_table1(id text)",
+					},
+				},
+				{
+					Id: "002",
+					Statements: []string{
+						"create table test_table2(id text)",
+					},
+				},
+			}
+			if _, err := RunMigrations(session, migrations[:1], -1); err != nil {
+				t.Errorf("unable to run migrations: %s", err)
+			}
+
+			completed, err := RunMigrations(session, migrations[1:], -1)
+			missingErr, ok := err.(MissingMigrationsError)
+			if !ok {
+				t.Errorf("expected MissingMigrationsError but got %v", err)
+				return
+			}
+			if len(missingErr.Ids) != 1 || missingErr.Ids[0] != "001" {
+				t.Errorf("MissingMigrationsError did not contain expected migration 001: %v", err)
+			}
+			if len(completed) > 0 {
+				t.Errorf("expected no completed migrations")
+			}
+
+			completed, err = RunMigrations(session, migrations[1:], -1, WithMissing(PolicyAllow))
+			if err != nil {
+				t.Errorf("failed to run migrations with missing migrations allowed: %v", err)
+			}
+			if len(completed) != 1 || completed[0] != "002" {
+				t.Errorf("expected migration 002 to be completed but got %v", completed)
+			}
+		})
+	})
+
+	t.Run("RunMigrations should roll back all statements of a failed migration", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{
+					Id: "001",
+					Statements: []string{
+						"create table test_table1(id text)",
+						"create table test_table1(id text)",
+					},
+				},
+			}
+			if _, err := RunMigrations(session, migrations, -1); err == nil {
+				t.Errorf("expected migration to fail")
+			}
+			verifyTableExistence(t, session, "test_table1", false)
+
+			// The rolled back migration is left pending rather than in progress
+			migrations[0].Statements = migrations[0].Statements[:1]
+			if _, err := RunMigrations(session, migrations, -1); err != nil {
+				t.Errorf("failed to rerun rolled back migration: %v", err)
+			}
+			verifyTableExistence(t, session, "test_table1", true)
+		})
+	})
+
+	t.Run("RunMigrations should retry migrations that hit the lock timeout", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := session.Exec("create table test_table1(id text)"); err != nil {
+				t.Fatalf("unable to create table: %s", err)
+			}
+			migrations := []Migration{
+				{
+					Id: "001",
+					Statements: []string{
+						"alter table test_table1 add column name text",
+					},
+				},
+			}
+
+			// Hold a conflicting lock on the table in another transaction
+			tx, err := session.Begin()
+			if err != nil {
+				t.Fatalf("unable to begin transaction: %s", err)
+			}
+			if _, err := tx.Exec("lock table test_table1 in access share mode"); err != nil {
+				t.Fatalf("unable to lock table: %s", err)
+			}
+
+			_, err = RunMigrations(session, migrations, 0, WithLockTimeout(100*time.Millisecond))
+			if err == nil {
+				t.Errorf("expected migration to fail on lock timeout")
+			}
+
+			go func() {
+				time.Sleep(300 * time.Millisecond)
+				tx.Rollback()
+			}()
+			completed, err := RunMigrations(session, migrations, 0, WithLockTimeout(100*time.Millisecond), WithLockRetries(5, 100*time.Millisecond))
+			if err != nil {
+				t.Errorf("failed to run migration with lock retries: %v", err)
+			}
+			if len(completed) != 1 {
+				t.Errorf("expected 1 completed migration but got %d", len(completed))
+			}
+		})
+	})
+
+	t.Run("RunMigrations should run migrations outside a transaction if requested", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{
+					Id: "001",
+					Statements: []string{
+						"create table test_table1(id text)",
+						"create index concurrently test_table1_idx on test_table1 (id)",
+					},
+					NoTransaction: true,
+				},
+			}
+			if _, err := RunMigrations(session, migrations, -1); err != nil {
+				t.Errorf("failed to run non-transactional migration: %v", err)
+			}
+		})
+	})
+
+	t.Run("RunMigrations should run migrations outside a transaction if a statement requires it", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{
+					Id: "001",
+					Statements: []string{
+						"create table test_table1(id text)",
+						"create index concurrently test_table1_idx on test_table1 (id)",
+						"vacuum test_table1",
+					},
+				},
+			}
+			if _, err := RunMigrations(session, migrations, -1); err != nil {
+				t.Errorf("failed to run migration with non-transactional statements: %v", err)
+			}
+		})
+	})
+
+	t.Run("RunMigrations should run all statements and hooks on the same connection", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			// Close connections as soon as they are released so nothing is reused by chance
+			session.SetMaxIdleConns(0)
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"insert into test_ids values ('a')"}},
+				{Id: "002", Statements: []string{"create table test_table1 as select id from test_ids"}},
+			}
+			hooks := SQLHooks{BeforeAll: []string{"create temporary table test_ids(id text)"}}
+			if _, err := RunMigrations(session, migrations, -1, WithSQLHooks(hooks)); err != nil {
+				t.Errorf("failed to run migrations using a temporary table: %v", err)
+			}
+			verifyTableExistence(t, session, "test_table1", true)
+		})
+	})
+
+	t.Run("RunMigrations should run repeatable migrations after versioned migrations when they change", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{
+					Id: "R__views",
+					Statements: []string{
+						"create or replace view test_view as select id from test_table1",
+					},
+					Repeatable: true,
+				},
+				{
+					Id: "001",
+					Statements: []string{
+						"create table test_table1(id text)",
+					},
+				},
+			}
+			completed, err := RunMigrations(session, migrations, -1)
+			if err != nil {
+				t.Errorf("failed to run migrations: %v", err)
+			}
+			if len(completed) != 2 || completed[0] != "001" || completed[1] != "R__views" {
+				t.Errorf("expected migrations 001 and R__views to be completed in order but got %v", completed)
+			}
+
+			// Unchanged repeatable migrations are skipped
+			completed, err = RunMigrations(session, migrations, -1)
+			if err != nil {
+				t.Errorf("failed to run migrations again: %v", err)
+			}
+			if len(completed) != 0 {
+				t.Errorf("expected no completed migrations but got %v", completed)
+			}
+
+			// Changed repeatable migrations are run again
+			migrations[0].Statements = []string{
+				"create or replace view test_view as select id, id as name from test_table1",
+			}
+			completed, err = RunMigrations(session, migrations, -1)
+			if err != nil {
+				t.Errorf("failed to run changed repeatable migration: %v", err)
+			}
+			if len(completed) != 1 || completed[0] != "R__views" {
+				t.Errorf("expected migration R__views to be completed but got %v", completed)
+			}
+		})
+	})
 }
 
 // -- Helper methods
 
+// parentSession opens a connection to the database configured through the environment. Ephemeral
+// sessions are created from it.
+func parentSession(t testing.TB) (db *sql.DB, host string, port int) {
+	t.Helper()
+	user := env.GetenvWithDefault("POSTGRES_USER", "postgres")
+	password := env.GetenvWithDefault("POSTGRES_PASSWORD", "postgres")
+	host = env.GetenvWithDefault("POSTGRES_HOST", "localhost")
+	portStr := env.GetenvWithDefault("POSTGRES_PORT", "5432")
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("invalid PORT %s", portStr)
+	}
+	database := env.GetenvWithDefault("POSTGRES_DATABASE", "postgres")
+
+	// Set up parent connection
+	connStr := fmt.Sprintf("user=%s password=%s host=%s port=%d database=%s sslmode=disable", user, password, host, port, database)
+	db, err = openConnection(connStr)
+	if err != nil {
+		t.Fatalf("failed to open connection %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return
+}
+
 func verifyTableExistence(
 	t testing.TB,
 	session *sql.DB,
@@ -298,6 +590,14 @@ func ephemeralSession(
 	block(session)
 }
 
+func getCurrentTime(session *sql.DB) time.Time {
+	ts, err := currentTime(newExecutor(session))
+	if err != nil {
+		panic(err)
+	}
+	return ts
+}
+
 func openConnection(connStr string) (db *sql.DB, err error) {
 	db, err = sql.Open("pgx", connStr)
 	if db != nil {
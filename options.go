@@ -0,0 +1,195 @@
+package pgmigrate
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Policy decides how RunMigrations reacts to an unexpected but recoverable condition.
+type Policy string
+
+const (
+	PolicyFail  Policy = "fail"
+	PolicyWarn  Policy = "warn"
+	PolicyAllow Policy = "allow"
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyFail, PolicyWarn, PolicyAllow:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid policy %s, must be one of fail, warn or allow", s)
+}
+
+type Option func(*options)
+
+type options struct {
+	outOfOrder Policy
+	missing    Policy
+
+	lockTimeout      time.Duration
+	statementTimeout time.Duration
+	lockRetries      int
+	lockRetryBackoff time.Duration
+
+	hooks    Hooks
+	sqlHooks SQLHooks
+
+	logger *slog.Logger
+
+	tracerProvider trace.TracerProvider
+	tracer         trace.Tracer
+
+	metrics *Metrics
+	// Labels of the gauges for the database and schema of the current run
+	metricLabels prometheus.Labels
+
+	appVersion string
+
+	schema string
+
+	parallelism int
+
+	phase Phase
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		outOfOrder: PolicyFail,
+		missing:    PolicyFail,
+
+		lockRetryBackoff: time.Second,
+
+		hooks: NopHooks{},
+
+		logger: slog.Default(),
+
+		tracerProvider: noop.NewTracerProvider(),
+
+		parallelism: 1,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.tracer = o.tracerProvider.Tracer(tracerName)
+	return o
+}
+
+// WithOutOfOrder sets the policy for pending migrations that sort before the latest applied migration.
+func WithOutOfOrder(policy Policy) Option {
+	return func(o *options) {
+		o.outOfOrder = policy
+	}
+}
+
+// WithMissing sets the policy for migrations recorded in the database that are missing from the
+// provided migrations, e.g. after intentionally squashing migration files.
+func WithMissing(policy Policy) Option {
+	return func(o *options) {
+		o.missing = policy
+	}
+}
+
+// WithLockTimeout sets lock_timeout for every migration that doesn't override it. Zero disables the timeout.
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.lockTimeout = timeout
+	}
+}
+
+// WithStatementTimeout sets statement_timeout for every migration that doesn't override it. Zero
+// disables the timeout.
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.statementTimeout = timeout
+	}
+}
+
+// WithLockRetries retries a migration up to retries times when it fails because lock_timeout was
+// reached. The wait before each retry starts at backoff and doubles with every attempt.
+func WithLockRetries(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.lockRetries = retries
+		o.lockRetryBackoff = backoff
+	}
+}
+
+// WithHooks registers callbacks that are called around the migration run.
+func WithHooks(hooks Hooks) Option {
+	return func(o *options) {
+		o.hooks = hooks
+	}
+}
+
+// WithSQLHooks registers statements that are run around the migration run.
+func WithSQLHooks(hooks SQLHooks) Option {
+	return func(o *options) {
+		o.sqlHooks = hooks
+	}
+}
+
+// WithLogger sets the logger used for all events. Defaults to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithTracerProvider enables tracing of migration runs with spans for the run, each migration and
+// each statement. Tracing is disabled by default.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = provider
+	}
+}
+
+// WithMetrics records Prometheus metrics about the run. See NewMetrics.
+func WithMetrics(metrics *Metrics) Option {
+	return func(o *options) {
+		o.metrics = metrics
+	}
+}
+
+// WithAppVersion sets the version of the application build that is recorded with every applied migration.
+func WithAppVersion(version string) Option {
+	return func(o *options) {
+		o.appVersion = version
+	}
+}
+
+// WithSchema runs migrations in the given schema, which is created if it doesn't exist. The schema
+// is prepended to the search_path for the duration of the run, and the schema gets its own
+// migrations table and advisory lock. Unqualified names that don't exist in the schema still
+// resolve to the schemas that follow it, such as public, so a statement like "alter table users"
+// alters public.users when the schema has no users table. Qualify names that must not fall back.
+func WithSchema(schema string) Option {
+	return func(o *options) {
+		o.schema = schema
+	}
+}
+
+// WithParallelism applies up to n versioned migrations at a time, each on a connection of its own, as
+// soon as the migrations they depend on have completed. Connections don't share session state such
+// as temporary tables set up by hooks, and hooks may be called concurrently. Sessions that aren't
+// pools always apply one migration at a time. The connection holding the advisory lock stays in use
+// for the whole run, so n is reduced to one less than the connection limit of the pool, e.g.
+// MaxOpenConns of a *sql.DB or MaxConns of a *pgxpool.Pool.
+func WithParallelism(n int) Option {
+	return func(o *options) {
+		o.parallelism = n
+	}
+}
+
+// WithPhase only applies the migrations of the given phase. A contract migration fails to run
+// unless the expand migrations it depends on have been applied.
+func WithPhase(phase Phase) Option {
+	return func(o *options) {
+		o.phase = phase
+	}
+}
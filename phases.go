@@ -0,0 +1,118 @@
+package pgmigrate
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Phase splits schema changes for zero-downtime deploys. Expand migrations are run before the new
+// application code rolls out and contract migrations after.
+type Phase string
+
+const (
+	PhaseExpand   Phase = "expand"
+	PhaseContract Phase = "contract"
+)
+
+func ParsePhase(s string) (Phase, error) {
+	switch p := Phase(s); p {
+	case PhaseExpand, PhaseContract:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid phase %s, must be one of expand or contract", s)
+}
+
+// phase returns the phase of the migration. Migrations that aren't tagged are expand migrations.
+func (m Migration) phase() Phase {
+	if m.Phase == "" {
+		return PhaseExpand
+	}
+	return m.Phase
+}
+
+// inPhase returns the migrations of the given phase, or all migrations if phase is empty
+func inPhase(migrations []Migration, phase Phase) []Migration {
+	if phase == "" {
+		return migrations
+	}
+	var filtered []Migration
+	for _, m := range migrations {
+		if m.phase() == phase {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// getUnappliedExpandMigrations returns the ids of the expand migrations that the pending contract
+// migration depends on, directly or through other migrations, and that are neither completed nor
+// pending in this run.
+func getUnappliedExpandMigrations(
+	records []record,
+	migrations []Migration,
+	pending []Migration,
+	dependencies map[string][]string,
+	m Migration,
+) (ids []string) {
+	isPending := func(id string) bool {
+		return slices.ContainsFunc(pending, func(p Migration) bool { return p.Id == id })
+	}
+	visited := map[string]bool{}
+	queue := slices.Clone(dependencies[m.Id])
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		i := slices.IndexFunc(migrations, func(d Migration) bool { return d.Id == id })
+		if i >= 0 && migrations[i].phase() == PhaseExpand && !isCompleted(records, id) && !isPending(id) {
+			ids = append(ids, id)
+		}
+		queue = append(queue, dependencies[id]...)
+	}
+	slices.Sort(ids)
+	return
+}
+
+// getUnappliedDependency returns a migration that the pending migration depends on directly and that
+// is neither completed nor pending in this run.
+func getUnappliedDependency(records []record, pending []Migration, dependencies map[string][]string, m Migration) (string, bool) {
+	for _, id := range dependencies[m.Id] {
+		if !isCompleted(records, id) && !slices.ContainsFunc(pending, func(p Migration) bool { return p.Id == id }) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func phaseOf(migrations []Migration, id string) Phase {
+	if i := slices.IndexFunc(migrations, func(m Migration) bool { return m.Id == id }); i >= 0 {
+		return migrations[i].phase()
+	}
+	return ""
+}
+
+func phaseQuery(migrationId string, phase Phase) batchQuery {
+	return batchQuery{"update migrations set phase = $2 where id = $1;", []any{migrationId, string(phase)}}
+}
+
+type ExpandNotAppliedError struct {
+	Id        string
+	ExpandIds []string
+}
+
+func (e ExpandNotAppliedError) Error() string {
+	return fmt.Sprintf("contract migration %s can't run before expand migrations %v are applied", e.Id, e.ExpandIds)
+}
+
+type UnappliedDependencyError struct {
+	Id           string
+	DependencyId string
+	Phase        Phase
+}
+
+func (e UnappliedDependencyError) Error() string {
+	return fmt.Sprintf("migration %s depends on unapplied %s migration %s", e.Id, e.Phase, e.DependencyId)
+}
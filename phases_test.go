@@ -0,0 +1,116 @@
+package pgmigrate
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPhases(t *testing.T) {
+	migrations := []Migration{
+		{Id: "001", Statements: []string{"create table test_table1(id text, name text)"}},
+		{Id: "002", Statements: []string{"alter table test_table1 drop column name"}, Phase: PhaseContract},
+		{Id: "003", Statements: []string{"create table test_table2(id text)"}, Phase: PhaseExpand},
+		{Id: "004", Statements: []string{"drop table test_table2"}, Phase: PhaseContract},
+	}
+
+	t.Run("expand migrations should not depend on earlier contract migrations", func(t *testing.T) {
+		dependencies, err := getDependencies(migrations)
+		if err != nil {
+			t.Fatalf("getDependencies() error = %v", err)
+		}
+		want := map[string][]string{"001": nil, "002": {"001"}, "003": {"001"}, "004": {"002", "003"}}
+		if !reflect.DeepEqual(dependencies, want) {
+			t.Errorf("got %v, want %v", dependencies, want)
+		}
+	})
+
+	// 003 declares a dependency on a contract migration, so it can't be applied in the expand phase
+	dependsOnContract := []Migration{
+		migrations[0],
+		migrations[1],
+		{Id: "003", Statements: []string{"create table test_table2(id text)"}, DependsOn: []string{"002"}},
+	}
+
+	t.Run("migrations should not be selected without the migrations they depend on", func(t *testing.T) {
+		dependencies, err := getDependencies(dependsOnContract)
+		if err != nil {
+			t.Fatalf("getDependencies() error = %v", err)
+		}
+		pending := inPhase(dependsOnContract, PhaseExpand)
+		if id, ok := getUnappliedDependency(nil, pending, dependencies, dependsOnContract[2]); !ok || id != "002" {
+			t.Errorf("expected unapplied dependency 002 but got %q", id)
+		}
+		if id, ok := getUnappliedDependency(nil, dependsOnContract, dependencies, dependsOnContract[2]); ok {
+			t.Errorf("expected no unapplied dependency but got %q", id)
+		}
+	})
+
+	db, host, port := parentSession(t)
+
+	t.Run("RunMigrations should only apply migrations of the chosen phase", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			completed, err := RunMigrations(session, migrations, -1, WithPhase(PhaseExpand))
+			if err != nil {
+				t.Fatalf("failed to run expand migrations: %v", err)
+			}
+			if !reflect.DeepEqual(completed, []string{"001", "003"}) {
+				t.Errorf("expected expand migrations 001 and 003 to be completed but got %v", completed)
+			}
+
+			completed, err = RunMigrations(session, migrations, -1, WithPhase(PhaseContract))
+			if err != nil {
+				t.Fatalf("failed to run contract migrations: %v", err)
+			}
+			if !reflect.DeepEqual(completed, []string{"002", "004"}) {
+				t.Errorf("expected contract migrations 002 and 004 to be completed but got %v", completed)
+			}
+			verifyTableExistence(t, session, "test_table2", false)
+
+			statuses, err := Status(session, migrations, -1)
+			if err != nil {
+				t.Fatalf("failed to get status: %v", err)
+			}
+			for i, phase := range []Phase{PhaseExpand, PhaseContract, PhaseExpand, PhaseContract} {
+				if statuses[i].Phase != phase {
+					t.Errorf("expected migration %s to be recorded in phase %s but got %s", statuses[i].Id, phase, statuses[i].Phase)
+				}
+			}
+		})
+	})
+
+	t.Run("RunMigrations should refuse contract migrations before their expand migrations", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			_, err := RunMigrations(session, migrations, -1, WithPhase(PhaseContract))
+			var expandErr ExpandNotAppliedError
+			if !errors.As(err, &expandErr) || expandErr.Id != "002" || !reflect.DeepEqual(expandErr.ExpandIds, []string{"001"}) {
+				t.Fatalf("expected ExpandNotAppliedError for 002 but got %v", err)
+			}
+			verifyTableExistence(t, session, "test_table1", false)
+		})
+	})
+
+	t.Run("RunMigrations should refuse migrations that depend on a migration of another phase", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			_, err := RunMigrations(session, dependsOnContract, -1, WithPhase(PhaseExpand))
+			var dependencyErr UnappliedDependencyError
+			if !errors.As(err, &dependencyErr) || dependencyErr.Id != "003" || dependencyErr.DependencyId != "002" || dependencyErr.Phase != PhaseContract {
+				t.Fatalf("expected UnappliedDependencyError for 003 but got %v", err)
+			}
+			verifyTableExistence(t, session, "test_table1", false)
+		})
+	})
+
+	t.Run("RunMigrations should apply both phases in order without a chosen phase", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			completed, err := RunMigrations(session, migrations, -1)
+			if err != nil {
+				t.Fatalf("failed to run migrations: %v", err)
+			}
+			if !reflect.DeepEqual(completed, []string{"001", "002", "003", "004"}) {
+				t.Errorf("expected all migrations to be completed but got %v", completed)
+			}
+		})
+	})
+}
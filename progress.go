@@ -0,0 +1,54 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+)
+
+// Statements of migrations that run outside a transaction are recorded as they complete, so that a
+// migration that is run again after a crash continues with the first unfinished statement.
+const createStatementProgressTable = `create table if not exists migrations_statement_progress(
+	migration_id varchar(255) primary key,
+	checksum varchar(64) not null,
+	completed_statements integer not null,
+	updated_at timestamptz not null
+);`
+
+// getStatementProgress returns the number of statements of the migration that were completed by an
+// earlier run. It fails if the migration changed since then.
+func getStatementProgress(ctx context.Context, session querier, m Migration) (completed int, err error) {
+	var checksum string
+	q := "select coalesce(max(checksum), ''), coalesce(max(completed_statements), 0) from migrations_statement_progress where migration_id = $1;"
+	if err = session.QueryRow(ctx, q, m.Id).Scan(&checksum, &completed); err != nil {
+		err = fmt.Errorf("failed to get progress of migration %s: %s", m.Id, err)
+		return
+	}
+	if completed > 0 && (checksum != m.Checksum() || completed > len(m.Statements)) {
+		err = PartiallyAppliedMigrationError{Id: m.Id, CompletedStatements: completed}
+	}
+	return
+}
+
+func recordStatementProgress(ctx context.Context, session querier, m Migration, completed int) error {
+	q := "insert into migrations_statement_progress (migration_id, checksum, completed_statements, updated_at) values ($1, $2, $3, current_timestamp) on conflict (migration_id) do update set checksum = excluded.checksum, completed_statements = excluded.completed_statements, updated_at = excluded.updated_at;"
+	if err := session.Exec(ctx, q, m.Id, m.Checksum(), completed); err != nil {
+		return fmt.Errorf("failed to record progress of migration %s: %s", m.Id, err)
+	}
+	return nil
+}
+
+func clearStatementProgress(ctx context.Context, session querier, migrationId string) error {
+	if err := session.Exec(ctx, "delete from migrations_statement_progress where migration_id = $1;", migrationId); err != nil {
+		return fmt.Errorf("failed to clear progress of migration %s: %s", migrationId, err)
+	}
+	return nil
+}
+
+type PartiallyAppliedMigrationError struct {
+	Id                  string
+	CompletedStatements int
+}
+
+func (e PartiallyAppliedMigrationError) Error() string {
+	return fmt.Sprintf("migration %s changed after %d of its statements were applied, restore it or clear its progress with repair", e.Id, e.CompletedStatements)
+}
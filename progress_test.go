@@ -0,0 +1,75 @@
+package pgmigrate
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestStatementProgress(t *testing.T) {
+	db, host, port := parentSession(t)
+
+	failing := Migration{
+		Id: "001",
+		Statements: []string{
+			"create table test_table1(id text)",
+			"insert into test_table1 values ('a')",
+			"insert into test_table2 values ('b')",
+		},
+		NoTransaction: true,
+	}
+
+	t.Run("RunMigrations should resume a non-transactional migration after the last completed statement", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := RunMigrations(session, []Migration{failing}, 0); err == nil {
+				t.Fatalf("expected migration 001 to fail")
+			}
+			if _, err := session.Exec("create table test_table2(id text);"); err != nil {
+				t.Fatalf("failed to create table: %v", err)
+			}
+
+			// The first two statements would fail or duplicate rows if they were run again
+			completed, err := RunMigrations(session, []Migration{failing}, 0)
+			if err != nil {
+				t.Fatalf("failed to resume migration: %v", err)
+			}
+			if len(completed) != 1 {
+				t.Errorf("expected migration 001 to be completed but got %v", completed)
+			}
+			var count int
+			if err := session.QueryRow("select count(*) from test_table1;").Scan(&count); err != nil || count != 1 {
+				t.Errorf("expected 1 row in test_table1 but got %d: %v", count, err)
+			}
+			if err := session.QueryRow("select count(*) from migrations_statement_progress;").Scan(&count); err != nil || count != 0 {
+				t.Errorf("expected progress to be cleared but got %d rows: %v", count, err)
+			}
+		})
+	})
+
+	t.Run("RunMigrations should refuse to resume a migration that changed", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := RunMigrations(session, []Migration{failing}, 0); err == nil {
+				t.Fatalf("expected migration 001 to fail")
+			}
+			changed := failing
+			changed.Statements = []string{"create table test_table1(id text)", "insert into test_table1 values ('c')"}
+
+			_, err := RunMigrations(session, []Migration{changed}, 0)
+			var partialErr PartiallyAppliedMigrationError
+			if !errors.As(err, &partialErr) || partialErr.CompletedStatements != 2 {
+				t.Fatalf("expected PartiallyAppliedMigrationError after 2 statements but got %v", err)
+			}
+
+			// Repair clears the progress so the migration starts over
+			if _, err := Repair(session, []Migration{changed}, ""); err != nil {
+				t.Fatalf("failed to repair: %v", err)
+			}
+			if _, err := session.Exec("drop table test_table1;"); err != nil {
+				t.Fatalf("failed to drop table: %v", err)
+			}
+			if _, err := RunMigrations(session, []Migration{changed}, 0); err != nil {
+				t.Errorf("failed to run repaired migration: %v", err)
+			}
+		})
+	})
+}
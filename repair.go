@@ -0,0 +1,132 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+type RepairResult struct {
+	// Ids of started migrations whose records were removed so they will be run again
+	Cleared []string
+	// Ids of migrations that were marked as completed
+	Completed []string
+	// Ids of completed migrations whose checksums were updated to match the provided migrations
+	Restamped []string
+}
+
+// Repair fixes up the migrations table under the advisory lock. Because no other run can hold the
+// lock at the same time, every started but uncompleted migration is stale and its record is removed
+// along with the progress of its statements or batch update.
+// If completeId is non-empty, that migration is marked as completed instead, e.g. after it was
+// finished by hand. Finally, the checksums of all completed versioned migrations are updated to
+// match the provided migrations.
+func Repair[S Session](
+	session S,
+	migrations []Migration,
+	completeId string,
+	opts ...Option,
+) (result RepairResult, err error) {
+	return repair(newExecutor(session), migrations, completeId, newOptions(opts))
+}
+
+func repair(
+	session executor,
+	migrations []Migration,
+	completeId string,
+	o options,
+) (result RepairResult, err error) {
+	conn, releaseConn, err := acquireConn(context.Background(), session, o.schema)
+	if err != nil {
+		return
+	}
+	defer releaseConn()
+	session = conn
+
+	release, err := acquireLock(context.Background(), session, schemaLockId(o.schema), o.logger)
+	if err != nil {
+		return
+	}
+	defer release()
+
+	if err = createSchema(session, o.schema); err != nil {
+		return
+	}
+	if err = initMigrationsTable(session); err != nil {
+		err = fmt.Errorf("failed to create migrations table: %v", err)
+		return
+	}
+
+	now, err := currentTime(session)
+	if err != nil {
+		return
+	}
+	metadata := newExecutionMetadata(o)
+
+	if completeId != "" {
+		if !slices.ContainsFunc(migrations, func(m Migration) bool { return m.Id == completeId }) {
+			err = fmt.Errorf("migration %s does not exist", completeId)
+			return
+		}
+		if err = markAsCompleted(session, completeId, now); err != nil {
+			return
+		}
+		if err = clearStatementProgress(context.Background(), session, completeId); err != nil {
+			return
+		}
+		if err = clearBatchProgress(context.Background(), session, completeId); err != nil {
+			return
+		}
+		if err = recordHistory(session, completeId, OperationRepair, now, metadata, nil); err != nil {
+			return
+		}
+		o.logger.Info("repair marked migration as completed", logKeyMigrationId, completeId)
+		result.Completed = append(result.Completed, completeId)
+	}
+
+	records, err := getAllRecords(session)
+	if err != nil {
+		err = fmt.Errorf("failed to read migrations: %v", err)
+		return
+	}
+
+	startedRecords, _ := getStartedRecords(records)
+	for _, r := range startedRecords {
+		if err = session.Exec(context.Background(), "delete from migrations where id = $1 and completed_at is null;", r.id); err != nil {
+			err = fmt.Errorf("failed to clear started migration %s: %v", r.id, err)
+			return
+		}
+		if err = clearStatementProgress(context.Background(), session, r.id); err != nil {
+			return
+		}
+		if err = clearBatchProgress(context.Background(), session, r.id); err != nil {
+			return
+		}
+		if err = recordHistory(session, r.id, OperationRepair, now, metadata, nil); err != nil {
+			return
+		}
+		o.logger.Info("repair cleared started migration", logKeyMigrationId, r.id, logKeyStartedAt, *r.startedAt)
+		result.Cleared = append(result.Cleared, r.id)
+	}
+
+	// Repeatable migrations are left alone as their checksums decide whether they are run again
+	for _, m := range versionedMigrations(migrations) {
+		i := slices.IndexFunc(records, func(r record) bool { return r.id == m.Id && r.completedAt != nil })
+		if i < 0 {
+			continue
+		}
+		checksum := m.Checksum()
+		if previous := records[i].checksum; previous != nil && *previous == checksum {
+			continue
+		}
+		if err = updateChecksum(session, m.Id, checksum); err != nil {
+			return
+		}
+		if err = recordHistory(session, m.Id, OperationRepair, now, metadata, nil); err != nil {
+			return
+		}
+		o.logger.Info("repair updated checksum", logKeyMigrationId, m.Id, logKeyChecksum, checksum)
+		result.Restamped = append(result.Restamped, m.Id)
+	}
+	return
+}
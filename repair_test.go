@@ -0,0 +1,86 @@
+package pgmigrate
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	db, host, port := parentSession(t)
+
+	migrations := []Migration{
+		{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+		{Id: "002", Statements: []string{"create table test_table2(id text)"}},
+		{Id: "003", Statements: []string{"create table test_table3(id text)"}},
+	}
+
+	t.Run("Repair should clear started migrations so they can be run again", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := RunMigrations(session, migrations[:1], -1); err != nil {
+				t.Errorf("unable to run migrations: %s", err)
+			}
+			markAsStarted(newExecutor(session), "002", getCurrentTime(session))
+
+			if _, err := RunMigrations(session, migrations, -1); err == nil {
+				t.Errorf("expected in-progress migration to block the run")
+			}
+
+			result, err := Repair(session, migrations, "")
+			if err != nil {
+				t.Errorf("unable to repair: %s", err)
+			}
+			if len(result.Cleared) != 1 || result.Cleared[0] != "002" {
+				t.Errorf("expected migration 002 to be cleared but got %v", result.Cleared)
+			}
+
+			completed, err := RunMigrations(session, migrations, -1)
+			if err != nil {
+				t.Errorf("failed to run migrations after repair: %v", err)
+			}
+			if len(completed) != 2 {
+				t.Errorf("expected 2 completed migrations but got %v", completed)
+			}
+		})
+	})
+
+	t.Run("Repair should mark a migration as completed", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			initMigrationsTable(newExecutor(session))
+			markAsStarted(newExecutor(session), "001", getCurrentTime(session))
+
+			result, err := Repair(session, migrations, "001")
+			if err != nil {
+				t.Errorf("unable to repair: %s", err)
+			}
+			if len(result.Completed) != 1 || result.Completed[0] != "001" || len(result.Cleared) != 0 {
+				t.Errorf("expected migration 001 to be completed but got %v", result)
+			}
+
+			records, err := getAllRecords(newExecutor(session))
+			if err != nil {
+				t.Errorf("unable to get migration records: %s", err)
+			}
+			if len(records) != 1 || records[0].completedAt == nil || records[0].checksum == nil || *records[0].checksum != migrations[0].Checksum() {
+				t.Errorf("expected migration 001 to be completed with a checksum but got %v", records)
+			}
+		})
+	})
+
+	t.Run("Repair should update checksums of completed migrations that changed", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := RunMigrations(session, migrations, -1); err != nil {
+				t.Errorf("unable to run migrations: %s", err)
+			}
+			changed := append([]Migration{}, migrations...)
+			changed[1] = Migration{Id: "002", Statements: []string{"create table if not exists test_table2(id text)"}}
+
+			result, err := Repair(session, changed, "")
+			if err != nil {
+				t.Errorf("unable to repair: %s", err)
+			}
+			if len(result.Restamped) != 1 || result.Restamped[0] != "002" {
+				t.Errorf("expected checksum of migration 002 to be updated but got %v", result.Restamped)
+			}
+		})
+	})
+}
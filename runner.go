@@ -1,17 +1,53 @@
 package pgmigrate
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/emillamm/pgmigrate/env"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-func Run() {
+type config struct {
+	user              string
+	password          string
+	host              string
+	port              int
+	database          string
+	migrationDir      string
+	retryAfterSeconds int
+	outOfOrder        Policy
+	missing           Policy
+	lockTimeout       time.Duration
+	statementTimeout  time.Duration
+	lockRetries       int
+	lockRetryBackoff  time.Duration
+	metricsFile       string
+	appVersion        string
+	schemas           []string
+	schemaPattern     string
+	schemaQuery       string
+	schemaConcurrency int
+	manifestFile      string
+	clusterDir        string
+	maintenanceDb     string
+	createDb          bool
+	createDbOptions   DatabaseOptions
+	parallelism       int
+	phase             Phase
+	logger            *slog.Logger
+}
+
+func loadConfig() config {
+	logger := NewLogger()
 	user := env.GetenvWithDefault("POSTGRES_USER", "")
 	// POSTGRES_PASS and POSTGRES_PASSWORD are both valid keys
 	password := env.GetenvWithDefault("POSTGRES_PASSWORD", env.GetenvWithDefault("POSTGRES_PASS", ""))
@@ -19,41 +55,464 @@ func Run() {
 	portStr := env.GetenvWithDefault("POSTGRES_PORT", "5432")
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
-		log.Fatalf("invalid PORT %s", portStr)
+		fatal(logger, "invalid PORT", logKeyValue, portStr)
 	}
 	database := env.GetenvWithDefault("POSTGRES_DATABASE", "postgres")
 	migrationDir := env.GetenvWithDefault("POSTGRES_MIGRATION_DIR", "migrations")
-	retryAfterSeconds, err := strconv.Atoi(env.GetenvWithDefault("POSTGRES_MIGRATION_RETRY_INTERVAL", "120"))
+	retryAfterSecondsStr := env.GetenvWithDefault("POSTGRES_MIGRATION_RETRY_INTERVAL", "120")
+	retryAfterSeconds, err := strconv.Atoi(retryAfterSecondsStr)
+	if err != nil {
+		fatal(logger, "invalid POSTGRES_MIGRATION_RETRY_INTERVAL", logKeyValue, retryAfterSecondsStr)
+	}
+	outOfOrder, err := ParsePolicy(env.GetenvWithDefault("POSTGRES_MIGRATION_OUT_OF_ORDER", string(PolicyFail)))
+	if err != nil {
+		fatal(logger, "invalid POSTGRES_MIGRATION_OUT_OF_ORDER", logKeyError, err)
+	}
+	missing, err := ParsePolicy(env.GetenvWithDefault("POSTGRES_MIGRATION_MISSING", string(PolicyFail)))
+	if err != nil {
+		fatal(logger, "invalid POSTGRES_MIGRATION_MISSING", logKeyError, err)
+	}
+	lockTimeout := getDurationEnv(logger, "POSTGRES_MIGRATION_LOCK_TIMEOUT", "0")
+	statementTimeout := getDurationEnv(logger, "POSTGRES_MIGRATION_STATEMENT_TIMEOUT", "0")
+	lockRetriesStr := env.GetenvWithDefault("POSTGRES_MIGRATION_LOCK_RETRIES", "0")
+	lockRetries, err := strconv.Atoi(lockRetriesStr)
 	if err != nil {
-		log.Fatalf("invalid POSTGRES_MIGRATION_RETRY_INTERVAL %d", port)
+		fatal(logger, "invalid POSTGRES_MIGRATION_LOCK_RETRIES", logKeyValue, lockRetriesStr)
+	}
+	lockRetryBackoff := getDurationEnv(logger, "POSTGRES_MIGRATION_LOCK_RETRY_BACKOFF", "1s")
+	// Path of a file that metrics are written to for the node_exporter textfile collector
+	metricsFile := env.GetenvWithDefault("POSTGRES_MIGRATION_METRICS_FILE", "")
+	appVersion := env.GetenvWithDefault("POSTGRES_MIGRATION_APP_VERSION", "")
+	// Migrations are applied to every schema listed, matching the LIKE pattern or returned by the query
+	var schemas []string
+	if schemasStr := env.GetenvWithDefault("POSTGRES_MIGRATION_SCHEMAS", ""); schemasStr != "" {
+		for _, schema := range strings.Split(schemasStr, ",") {
+			schemas = append(schemas, strings.TrimSpace(schema))
+		}
+	}
+	schemaPattern := env.GetenvWithDefault("POSTGRES_MIGRATION_SCHEMA_PATTERN", "")
+	schemaQuery := env.GetenvWithDefault("POSTGRES_MIGRATION_SCHEMA_QUERY", "")
+	schemaConcurrencyStr := env.GetenvWithDefault("POSTGRES_MIGRATION_SCHEMA_CONCURRENCY", "1")
+	schemaConcurrency, err := strconv.Atoi(schemaConcurrencyStr)
+	if err != nil || schemaConcurrency < 1 {
+		fatal(logger, "invalid POSTGRES_MIGRATION_SCHEMA_CONCURRENCY", logKeyValue, schemaConcurrencyStr)
+	}
+	// Path of a JSON manifest listing several databases to migrate instead of POSTGRES_DATABASE
+	manifestFile := env.GetenvWithDefault("POSTGRES_MIGRATION_MANIFEST", "")
+	// Cluster migrations, e.g. creating roles and databases, run through the maintenance database first
+	clusterDir := env.GetenvWithDefault("POSTGRES_CLUSTER_MIGRATION_DIR", "")
+	maintenanceDb := env.GetenvWithDefault("POSTGRES_MAINTENANCE_DATABASE", "postgres")
+	// Create POSTGRES_DATABASE through the maintenance database if it doesn't exist
+	createDbStr := env.GetenvWithDefault("POSTGRES_CREATE_DATABASE", "false")
+	createDb, err := strconv.ParseBool(createDbStr)
+	if err != nil {
+		fatal(logger, "invalid POSTGRES_CREATE_DATABASE", logKeyValue, createDbStr)
+	}
+	createDbOptions := DatabaseOptions{
+		Owner:    env.GetenvWithDefault("POSTGRES_CREATE_DATABASE_OWNER", ""),
+		Encoding: env.GetenvWithDefault("POSTGRES_CREATE_DATABASE_ENCODING", ""),
+		Template: env.GetenvWithDefault("POSTGRES_CREATE_DATABASE_TEMPLATE", ""),
+	}
+	parallelismStr := env.GetenvWithDefault("POSTGRES_MIGRATION_PARALLELISM", "1")
+	parallelism, err := strconv.Atoi(parallelismStr)
+	if err != nil || parallelism < 1 {
+		fatal(logger, "invalid POSTGRES_MIGRATION_PARALLELISM", logKeyValue, parallelismStr)
+	}
+	// Only apply the migrations of the expand or contract phase when set
+	var phase Phase
+	if phaseStr := env.GetenvWithDefault("POSTGRES_MIGRATION_PHASE", ""); phaseStr != "" {
+		if phase, err = ParsePhase(phaseStr); err != nil {
+			fatal(logger, "invalid POSTGRES_MIGRATION_PHASE", logKeyError, err)
+		}
 	}
+	return config{
+		user:              user,
+		password:          password,
+		host:              host,
+		port:              port,
+		database:          database,
+		migrationDir:      migrationDir,
+		retryAfterSeconds: retryAfterSeconds,
+		outOfOrder:        outOfOrder,
+		missing:           missing,
+		lockTimeout:       lockTimeout,
+		statementTimeout:  statementTimeout,
+		lockRetries:       lockRetries,
+		lockRetryBackoff:  lockRetryBackoff,
+		metricsFile:       metricsFile,
+		appVersion:        appVersion,
+		schemas:           schemas,
+		schemaPattern:     schemaPattern,
+		schemaQuery:       schemaQuery,
+		schemaConcurrency: schemaConcurrency,
+		manifestFile:      manifestFile,
+		clusterDir:        clusterDir,
+		maintenanceDb:     maintenanceDb,
+		createDb:          createDb,
+		createDbOptions:   createDbOptions,
+		parallelism:       parallelism,
+		phase:             phase,
+		logger:            logger,
+	}
+}
+
+// NewLogger creates the logger used by the Run functions, writing to stderr in the format given by
+// POSTGRES_MIGRATION_LOG_FORMAT (text or json) at the level given by POSTGRES_MIGRATION_LOG_LEVEL.
+func NewLogger() *slog.Logger {
+	var level slog.Level
+	levelStr := env.GetenvWithDefault("POSTGRES_MIGRATION_LOG_LEVEL", "info")
+	levelErr := level.UnmarshalText([]byte(levelStr))
+	handlerOptions := &slog.HandlerOptions{Level: level}
+
+	var logger *slog.Logger
+	switch format := env.GetenvWithDefault("POSTGRES_MIGRATION_LOG_FORMAT", "text"); format {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(os.Stderr, handlerOptions))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, handlerOptions))
+	default:
+		logger = slog.New(slog.NewTextHandler(os.Stderr, handlerOptions))
+		fatal(logger, "invalid POSTGRES_MIGRATION_LOG_FORMAT", logKeyValue, format)
+	}
+	if levelErr != nil {
+		fatal(logger, "invalid POSTGRES_MIGRATION_LOG_LEVEL", logKeyValue, levelStr)
+	}
+	return logger
+}
+
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
 
-	session, err := getSession(user, password, host, database, port)
+func getDurationEnv(logger *slog.Logger, name string, defaultValue string) time.Duration {
+	value := env.GetenvWithDefault(name, defaultValue)
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fatal(logger, "invalid "+name, logKeyValue, value)
+	}
+	return d
+}
+
+func (c config) options() []Option {
+	return []Option{
+		WithOutOfOrder(c.outOfOrder),
+		WithMissing(c.missing),
+		WithLockTimeout(c.lockTimeout),
+		WithStatementTimeout(c.statementTimeout),
+		WithLockRetries(c.lockRetries, c.lockRetryBackoff),
+		WithLogger(c.logger),
+		WithAppVersion(c.appVersion),
+		WithParallelism(c.parallelism),
+		WithPhase(c.phase),
+	}
+}
+
+func (c config) connect() *sql.DB {
+	session, err := c.open()
+	if err != nil {
+		fatal(c.logger, "unable to connect to postgres", logKeyUser, c.user, logKeyHost, c.host, logKeyPort, c.port, logKeyDatabase, c.database, logKeyError, err)
+	}
+	return session
+}
+
+func (c config) open() (*sql.DB, error) {
+	session, err := getSession(c.logger, c.user, c.password, c.host, c.database, c.port)
 	if err == nil {
 		err = session.Ping()
 	}
+	return session, err
+}
+
+func Run() {
+	c := loadConfig()
+
+	var opts []Option
+	var registry *prometheus.Registry
+	if c.metricsFile != "" {
+		registry = prometheus.NewRegistry()
+		metrics, err := NewMetrics(registry)
+		if err != nil {
+			fatal(c.logger, "unable to register metrics", logKeyError, err)
+		}
+		opts = append(opts, WithMetrics(metrics))
+	}
+
+	var err error
+	if c.clusterDir != "" {
+		err = c.migrateCluster(opts)
+	}
+	if err == nil && c.manifestFile != "" {
+		err = c.runManifestFile(opts)
+	} else if err == nil {
+		_, err = c.migrate(opts)
+	}
+	if registry != nil {
+		if err := prometheus.WriteToTextfile(c.metricsFile, registry); err != nil {
+			c.logger.Error("unable to write metrics", logKeyPath, c.metricsFile, logKeyError, err)
+		}
+	}
+	if err != nil {
+		fatal(c.logger, "unable to complete some or all migrations", logKeyError, err)
+	}
+}
+
+// migrate applies the migrations in the configured directory to the configured database.
+func (c config) migrate(opts []Option) (completed []string, err error) {
+	provider := FileMigrationProvider{Directory: c.migrationDir}
+	migrations, err := provider.ReadMigrations()
+	if err != nil {
+		return
+	}
+	hooks, err := provider.ReadSQLHooks()
+	if err != nil {
+		return
+	}
+	if c.createDb {
+		if err = c.createDatabase(); err != nil {
+			return
+		}
+	}
+	session, err := c.open()
+	if err != nil {
+		err = fmt.Errorf("unable to connect to database %s: %v", c.database, err)
+		return
+	}
+	defer session.Close()
+
+	opts = append(append(c.options(), opts...), WithSQLHooks(hooks))
+	if c.fanOut() {
+		return c.runForSchemas(session, migrations, opts)
+	}
+	return RunMigrations(session, migrations, c.retryAfterSeconds, opts...)
+}
+
+// createDatabase creates the configured database through the maintenance database if it doesn't exist.
+func (c config) createDatabase() error {
+	mc := c
+	mc.database = c.maintenanceDb
+	session, err := mc.open()
+	if err != nil {
+		return fmt.Errorf("unable to connect to maintenance database %s: %v", mc.database, err)
+	}
+	defer session.Close()
+
+	created, err := CreateDatabaseIfNotExists(session, c.database, c.createDbOptions)
+	if err != nil {
+		return err
+	}
+	if created {
+		c.logger.Info("created database", logKeyDatabase, c.database)
+	}
+	return nil
+}
+
+// migrateCluster applies the cluster migrations through the maintenance database.
+func (c config) migrateCluster(opts []Option) error {
+	provider := FileMigrationProvider{Directory: c.clusterDir}
+	migrations, err := provider.ReadMigrations()
+	if err != nil {
+		return err
+	}
+	c.database = c.maintenanceDb
+	session, err := c.open()
+	if err != nil {
+		return fmt.Errorf("unable to connect to maintenance database %s: %v", c.database, err)
+	}
+	defer session.Close()
+
+	opts = append(c.options(), opts...)
+	if _, err := RunClusterMigrations(session, migrations, c.retryAfterSeconds, opts...); err != nil {
+		return fmt.Errorf("cluster migrations failed: %v", err)
+	}
+	return nil
+}
+
+// runManifestFile migrates all targets of the manifest, prints a report to stdout and returns an
+// error if any target failed.
+func (c config) runManifestFile(opts []Option) error {
+	m, err := loadManifest(c.manifestFile)
 	if err != nil {
-		log.Fatalf("unable to connect to postgres database with user=%s host=%s port=%d database=%s: %v", user, host, port, database, err)
+		return err
+	}
+	results := c.runManifest(m, opts)
+	if err := writeManifestReport(os.Stdout, results); err != nil {
+		c.logger.Error("unable to write manifest report", logKeyError, err)
+	}
+	var failed []string
+	for _, r := range results {
+		if r.err != nil {
+			failed = append(failed, r.name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("migrations failed for targets %v", failed)
+	}
+	return nil
+}
+
+func (c config) fanOut() bool {
+	return len(c.schemas) > 0 || c.schemaPattern != "" || c.schemaQuery != ""
+}
+
+// runForSchemas applies the migrations to all configured schemas and returns an error if any of them
+// failed. The ids of migrations that were completed in at least one schema are returned in order.
+func (c config) runForSchemas(session *sql.DB, migrations []Migration, opts []Option) (completed []string, err error) {
+	schemas := slices.Clone(c.schemas)
+	if c.schemaPattern != "" {
+		matching, err := SchemasMatching(session, c.schemaPattern)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, matching...)
+	}
+	if c.schemaQuery != "" {
+		queried, err := SchemasFromQuery(session, c.schemaQuery)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, queried...)
+	}
+	slices.Sort(schemas)
+	schemas = slices.Compact(schemas)
+
+	results := RunMigrationsForSchemas(context.Background(), session, schemas, migrations, c.retryAfterSeconds, c.schemaConcurrency, opts...)
+	var failed []string
+	applied := make(map[string]bool)
+	for _, r := range results {
+		for _, id := range r.Completed {
+			applied[id] = true
+		}
+		if r.Err != nil {
+			failed = append(failed, r.Schema)
+			c.logger.Error("schema failed", logKeySchema, r.Schema, logKeyMigrationIds, r.Completed, logKeyError, r.Err)
+		} else {
+			c.logger.Info("schema completed", logKeySchema, r.Schema, logKeyMigrationIds, r.Completed)
+		}
+	}
+	c.logger.Info("schema fan-out completed", logKeySchemas, len(results), logKeyFailed, len(failed))
+	for _, m := range migrations {
+		if applied[m.Id] {
+			completed = append(completed, m.Id)
+		}
+	}
+	if len(failed) > 0 {
+		err = fmt.Errorf("migrations failed in schemas %v", failed)
 	}
+	return
+}
+
+// readMigrations reads the migrations of the provider and exits if any of them can't be read.
+func (c config) readMigrations(provider FileMigrationProvider) []Migration {
+	migrations, err := provider.ReadMigrations()
+	if err != nil {
+		fatal(c.logger, "unable to read migrations", logKeyError, err)
+	}
+	return migrations
+}
+
+// RunStatus prints the status of all migrations to stdout in the given format ("table" or "json").
+func RunStatus(format string) {
+	c := loadConfig()
+	session := c.connect()
+
+	provider := FileMigrationProvider{Directory: c.migrationDir}
+	statuses, err := Status(session, c.readMigrations(provider), c.retryAfterSeconds)
+	if err != nil {
+		fatal(c.logger, "unable to get migration status", logKeyError, err)
+	}
+	switch format {
+	case "table":
+		err = WriteStatusTable(os.Stdout, statuses)
+	case "json":
+		err = WriteStatusJSON(os.Stdout, statuses)
+	default:
+		fatal(c.logger, "invalid status format", logKeyFormat, format)
+	}
+	if err != nil {
+		fatal(c.logger, "unable to write migration status", logKeyError, err)
+	}
+}
+
+// RunHistory prints every recorded attempt for the migration with the given id, or for all migrations if id is empty.
+func RunHistory(id string, format string) {
+	c := loadConfig()
+	session := c.connect()
+
+	entries, err := History(session, id)
+	if err != nil {
+		fatal(c.logger, "unable to get migration history", logKeyError, err)
+	}
+	switch format {
+	case "table":
+		err = WriteHistoryTable(os.Stdout, entries)
+	case "json":
+		err = WriteHistoryJSON(os.Stdout, entries)
+	default:
+		fatal(c.logger, "invalid history format", logKeyFormat, format)
+	}
+	if err != nil {
+		fatal(c.logger, "unable to write migration history", logKeyError, err)
+	}
+}
+
+// RunLint checks the migrations for dangerous statements, prints the violations to stdout and exits
+// with a non-zero status if there are any.
+func RunLint() {
+	c := loadConfig()
+
+	provider := FileMigrationProvider{Directory: c.migrationDir}
+	violations := Lint(c.readMigrations(provider))
+	if len(violations) == 0 {
+		c.logger.Info("no lint violations found")
+		return
+	}
+	if err := WriteLintReport(os.Stdout, violations); err != nil {
+		fatal(c.logger, "unable to write lint report", logKeyError, err)
+	}
+	os.Exit(1)
+}
+
+// RunBaseline records all migrations up to and including the given id as completed without running them.
+func RunBaseline(id string) {
+	c := loadConfig()
+	if id == "" {
+		fatal(c.logger, "baseline requires the id of a migration")
+	}
+	session := c.connect()
+
+	provider := FileMigrationProvider{Directory: c.migrationDir}
+	baselined, err := Baseline(session, c.readMigrations(provider), id, c.options()...)
+	if err != nil {
+		fatal(c.logger, "unable to baseline migrations", logKeyError, err)
+	}
+	c.logger.Info("baselined migrations", logKeyMigrationIds, baselined)
+}
+
+// RunRepair clears stale started migrations, optionally marks the migration with the given id as
+// completed and updates checksums of completed migrations.
+func RunRepair(completeId string) {
+	c := loadConfig()
+	session := c.connect()
 
-	provider := FileMigrationProvider{Directory: migrationDir}
-	migrations := provider.GetMigrations()
-	completed, err := RunMigrations(session, migrations, retryAfterSeconds)
-	log.Printf("completed %d migrations: %v\n", len(completed), completed)
+	provider := FileMigrationProvider{Directory: c.migrationDir}
+	result, err := Repair(session, c.readMigrations(provider), completeId, c.options()...)
 	if err != nil {
-		log.Fatalf("unable to complete some or all migrations: %v", err)
+		fatal(c.logger, "unable to repair migrations", logKeyError, err)
 	}
+	c.logger.Info("repaired migrations", logKeyCleared, result.Cleared, logKeyCompleted, result.Completed, logKeyRestamped, result.Restamped)
 }
 
-func getSession(user, password, host, database string, port int) (session *sql.DB, err error) {
+func getSession(logger *slog.Logger, user, password, host, database string, port int) (session *sql.DB, err error) {
 	connStr := fmt.Sprintf("user=%s password=%s host=%s port=%d database=%s sslmode=disable", user, password, host, port, database)
 	for i := 0; i < 4; i++ {
+		logger.Debug("connecting to postgres", logKeyHost, host, logKeyPort, port, logKeyDatabase, database, logKeyAttempt, i+1)
 		session, err = sql.Open("pgx", connStr)
 		if err == nil {
 			err = session.Ping()
 		}
 		if err != nil {
-			log.Println(fmt.Sprintf("failed to connect to postgres..."))
+			logger.Warn("failed to connect to postgres", logKeyHost, host, logKeyPort, port, logKeyDatabase, database, logKeyAttempt, i+1, logKeyError, err)
 			time.Sleep(time.Second * 5)
 		} else {
 			break
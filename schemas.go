@@ -0,0 +1,150 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SchemaResult is the outcome of running migrations in a single schema.
+type SchemaResult struct {
+	Schema    string
+	Completed []string
+	Err       error
+}
+
+// RunMigrationsForSchemas applies the migrations to each schema as if RunMigrations was called with
+// WithSchema, running at most concurrency schemas at a time. A failure in one schema doesn't stop the
+// others. Results are returned in the order of schemas. A *pgx.Conn can't be shared between runs, so
+// its schemas are migrated one at a time. Concurrency is reduced so that all concurrent runs fit into
+// the connection limit of the pool.
+func RunMigrationsForSchemas[S Session](
+	ctx context.Context,
+	session S,
+	schemas []string,
+	migrations []Migration,
+	retryAfterSeconds int,
+	concurrency int,
+	opts ...Option,
+) []SchemaResult {
+	e := newExecutor(session)
+	if !e.Pooled() || concurrency < 1 {
+		concurrency = 1
+	}
+	// Every run holds a connection for its advisory lock and one more for each migration it applies
+	// concurrently
+	if limit := e.MaxConns(); limit > 0 {
+		perRun := 1
+		if parallelism := newOptions(opts).parallelism; parallelism > 1 {
+			perRun += parallelism
+		}
+		concurrency = max(min(concurrency, limit/perRun), 1)
+	}
+
+	results := make([]SchemaResult, len(schemas))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, schema := range schemas {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			o := newOptions(append(opts[:len(opts):len(opts)], WithSchema(schema)))
+			o.logger = o.logger.With(logKeySchema, schema)
+			completed, err := runMigrations(ctx, e, migrations, retryAfterSeconds, o)
+			results[i] = SchemaResult{Schema: schema, Completed: completed, Err: err}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// SchemasMatching returns the names of the schemas matching a LIKE pattern such as "tenant_%".
+func SchemasMatching[S Session](session S, pattern string) ([]string, error) {
+	return SchemasFromQuery(session, "select nspname from pg_namespace where nspname like $1 order by nspname;", pattern)
+}
+
+// SchemasFromQuery returns the schema names found in the first column of the rows returned by query.
+func SchemasFromQuery[S Session](session S, query string, args ...any) (schemas []string, err error) {
+	rows, err := newExecutor(session).Query(context.Background(), query, args...)
+	if err != nil {
+		err = fmt.Errorf("failed to list schemas: %v", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var schema string
+		if err = rows.Scan(&schema); err != nil {
+			err = fmt.Errorf("failed to scan schema name: %v", err)
+			return
+		}
+		schemas = append(schemas, schema)
+	}
+	err = rows.Err()
+	return
+}
+
+// acquireConn returns a dedicated connection with schema prepended to its search_path, unless schema
+// is empty, so extensions, types and functions installed in e.g. public still resolve. The returned
+// function restores the search_path and releases the connection.
+func acquireConn(ctx context.Context, session executor, schema string) (conn executor, release func(), err error) {
+	conn, releaseConn, err := session.Acquire(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to get connection: %v", err)
+		return
+	}
+	if schema == "" {
+		release = releaseConn
+		return
+	}
+	var previous string
+	if err = conn.QueryRow(ctx, "select current_setting('search_path');").Scan(&previous); err == nil {
+		path := quoteIdentifier(schema)
+		if previous != "" {
+			path += ", " + previous
+		}
+		err = conn.Exec(ctx, "select set_config('search_path', $1, false);", path)
+	}
+	if err != nil {
+		releaseConn()
+		err = fmt.Errorf("failed to set search_path to schema %s: %v", schema, err)
+		return
+	}
+	release = func() {
+		conn.Exec(context.Background(), "select set_config('search_path', $1, false);", previous)
+		releaseConn()
+	}
+	return
+}
+
+// tableExists reports whether table exists in schema, or anywhere on the search_path if schema is
+// empty. Tables in the schemas after schema on the search_path don't count.
+func tableExists(session querier, schema string, table string) (exists bool, err error) {
+	q := "select to_regclass(case when $1::text = '' then quote_ident($2::text) else format('%I.%I', $1::text, $2::text) end) is not null;"
+	err = session.QueryRow(context.Background(), q, schema, table).Scan(&exists)
+	return
+}
+
+func createSchema(session querier, schema string) error {
+	if schema == "" {
+		return nil
+	}
+	if err := session.Exec(context.Background(), "create schema if not exists "+quoteIdentifier(schema)+";"); err != nil {
+		return fmt.Errorf("failed to create schema %s: %v", schema, err)
+	}
+	return nil
+}
+
+func quoteIdentifier(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,106 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestSchemas(t *testing.T) {
+	t.Run("schemaLockId should differ per schema", func(t *testing.T) {
+		if schemaLockId("") != advisoryLockId {
+			t.Errorf("expected the default lock id without a schema")
+		}
+		if schemaLockId("tenant_a") == schemaLockId("tenant_b") || schemaLockId("tenant_a") == advisoryLockId {
+			t.Errorf("expected distinct lock ids per schema")
+		}
+	})
+
+	db, host, port := parentSession(t)
+
+	t.Run("RunMigrationsForSchemas should apply migrations to every schema", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"insert into test_table1 values ('a')"}},
+			}
+			if _, err := session.Exec("create schema tenant_a; create schema tenant_b; create schema other;"); err != nil {
+				t.Fatalf("unable to create schemas: %s", err)
+			}
+			schemas, err := SchemasMatching(session, "tenant_%")
+			if err != nil {
+				t.Fatalf("unable to list schemas: %s", err)
+			}
+			schemas = append(schemas, "tenant_c")
+			if len(schemas) != 3 || schemas[0] != "tenant_a" || schemas[1] != "tenant_b" {
+				t.Fatalf("unexpected schemas %v", schemas)
+			}
+
+			results := RunMigrationsForSchemas(context.Background(), session, schemas, migrations, -1, 2)
+			for i, r := range results {
+				if r.Schema != schemas[i] || r.Err != nil || len(r.Completed) != 2 {
+					t.Errorf("unexpected result %v", r)
+				}
+				var count int
+				if err := session.QueryRow("select count(*) from " + quoteIdentifier(r.Schema) + ".test_table1;").Scan(&count); err != nil || count != 1 {
+					t.Errorf("expected one row in %s.test_table1 but got %d: %v", r.Schema, count, err)
+				}
+				statuses, err := Status(session, migrations, -1, WithSchema(r.Schema))
+				if err != nil || len(statuses) != 2 || statuses[1].State != StateApplied {
+					t.Errorf("unexpected statuses %v in schema %s: %v", statuses, r.Schema, err)
+				}
+			}
+			verifyTableExistence(t, session, "migrations", false)
+
+			// The search_path is restored once a run is done
+			var searchPath string
+			if err := session.QueryRow("show search_path;").Scan(&searchPath); err != nil || searchPath != `"$user", public` {
+				t.Errorf("unexpected search_path %s: %v", searchPath, err)
+			}
+		})
+	})
+
+	t.Run("RunMigrationsForSchemas should report failures per schema", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := session.Exec("create schema tenant_a; create table tenant_a.test_table1(id text);"); err != nil {
+				t.Fatalf("unable to create schema: %s", err)
+			}
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+			}
+			results := RunMigrationsForSchemas(context.Background(), session, []string{"tenant_a", "tenant_b"}, migrations, -1, 1)
+			if results[0].Err == nil || len(results[0].Completed) != 0 {
+				t.Errorf("expected migrations to fail in tenant_a but got %v", results[0])
+			}
+			if results[1].Err != nil || len(results[1].Completed) != 1 {
+				t.Errorf("expected migrations to complete in tenant_b but got %v", results[1])
+			}
+		})
+	})
+
+	t.Run("RunMigrations should resolve objects in public from a schema", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			if _, err := session.Exec("create function public.test_answer() returns int language sql as 'select 42';"); err != nil {
+				t.Fatalf("unable to create function: %s", err)
+			}
+			// A migrations table in public must not be mistaken for the one of the schema
+			if _, err := RunMigrations(session, []Migration{{Id: "000", Statements: []string{"select 1"}}}, -1); err != nil {
+				t.Fatalf("failed to run migrations in public: %v", err)
+			}
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1 as select test_answer() as answer"}},
+			}
+			statuses, err := Status(session, migrations, -1, WithSchema("tenant_a"))
+			if err != nil || len(statuses) != 1 || statuses[0].State != StatePending {
+				t.Errorf("expected migration 001 to be pending in tenant_a but got %v: %v", statuses, err)
+			}
+			if _, err := RunMigrations(session, migrations, -1, WithSchema("tenant_a")); err != nil {
+				t.Fatalf("failed to run migrations in tenant_a: %v", err)
+			}
+			var answer int
+			if err := session.QueryRow("select answer from tenant_a.test_table1;").Scan(&answer); err != nil || answer != 42 {
+				t.Errorf("expected 42 in tenant_a.test_table1 but got %d: %v", answer, err)
+			}
+		})
+	})
+}
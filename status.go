@@ -0,0 +1,215 @@
+package pgmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type MigrationState string
+
+const (
+	StatePending    MigrationState = "pending"
+	StateApplied    MigrationState = "applied"
+	StateInProgress MigrationState = "in-progress"
+	StateStale      MigrationState = "stale"
+	StateMissing    MigrationState = "missing"
+)
+
+type MigrationStatus struct {
+	Id          string         `json:"id"`
+	State       MigrationState `json:"state"`
+	StartedAt   *time.Time     `json:"started_at,omitempty"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+	// Encoded as duration_ms in JSON
+	Duration    time.Duration `json:"-"`
+	Baseline    bool          `json:"baseline,omitempty"`
+	AppliedBy   string        `json:"applied_by,omitempty"`
+	Hostname    string        `json:"hostname,omitempty"`
+	ToolVersion string        `json:"tool_version,omitempty"`
+	AppVersion  string        `json:"app_version,omitempty"`
+	Phase       Phase         `json:"phase,omitempty"`
+}
+
+// Status reports the state of every migration known either to the provided migrations or to the
+// migrations table. Started migrations are reported as stale once retryAfterSeconds has passed,
+// matching the point at which RunMigrations would retry them.
+func Status[S Session](
+	session S,
+	migrations []Migration,
+	retryAfterSeconds int,
+	opts ...Option,
+) (statuses []MigrationStatus, err error) {
+	o := newOptions(opts)
+	conn, release, err := acquireConn(context.Background(), newExecutor(session), o.schema)
+	if err != nil {
+		return
+	}
+	defer release()
+	return getStatuses(conn, o.schema, migrations, retryAfterSeconds)
+}
+
+func getStatuses(
+	session querier,
+	schema string,
+	migrations []Migration,
+	retryAfterSeconds int,
+) (statuses []MigrationStatus, err error) {
+	exists, err := tableExists(session, schema, "migrations")
+	if err != nil {
+		err = fmt.Errorf("failed to look up migrations table: %v", err)
+		return
+	}
+
+	var records []record
+	if exists {
+		if records, err = getAllRecords(session); err != nil {
+			err = fmt.Errorf("failed to read migrations: %v", err)
+			return
+		}
+	}
+	now, err := currentTime(session)
+	if err != nil {
+		return
+	}
+
+	for _, m := range migrations {
+		status := MigrationStatus{Id: m.Id, State: StatePending}
+		if i := slices.IndexFunc(records, func(r record) bool { return r.id == m.Id }); i >= 0 {
+			status = recordStatus(records[i], now, retryAfterSeconds)
+			// Repeatable migrations are run again when they change
+			if m.Repeatable && status.State == StateApplied && !isCurrent(records, m) {
+				status.State = StatePending
+			}
+		}
+		if status.Phase == "" {
+			status.Phase = m.phase()
+		}
+		statuses = append(statuses, status)
+	}
+
+	var missing []MigrationStatus
+	for _, r := range records {
+		if slices.ContainsFunc(migrations, func(m Migration) bool { return m.Id == r.id }) {
+			continue
+		}
+		status := recordStatus(r, now, retryAfterSeconds)
+		if status.State == StateApplied {
+			status.State = StateMissing
+		}
+		missing = append(missing, status)
+	}
+	slices.SortFunc(missing, func(a, b MigrationStatus) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+	statuses = append(statuses, missing...)
+	return
+}
+
+func recordStatus(r record, currentTime time.Time, retryAfterSeconds int) MigrationStatus {
+	status := MigrationStatus{
+		Id:          r.id,
+		StartedAt:   r.startedAt,
+		CompletedAt: r.completedAt,
+		Baseline:    r.baseline,
+		AppliedBy:   valueOrEmpty(r.appliedBy),
+		Hostname:    valueOrEmpty(r.hostname),
+		ToolVersion: valueOrEmpty(r.toolVersion),
+		AppVersion:  valueOrEmpty(r.appVersion),
+		Phase:       Phase(valueOrEmpty(r.phase)),
+	}
+	switch {
+	case r.completedAt != nil:
+		status.State = StateApplied
+		if r.durationMs != nil {
+			status.Duration = time.Duration(*r.durationMs) * time.Millisecond
+		} else if r.startedAt != nil {
+			status.Duration = r.completedAt.Sub(*r.startedAt)
+		}
+	case r.startedAt != nil:
+		status.State = StateInProgress
+		secondsSinceStart := currentTime.Sub(*r.startedAt).Seconds()
+		if retryAfterSeconds >= 0 && float64(retryAfterSeconds) <= secondsSinceStart {
+			status.State = StateStale
+		}
+	default:
+		status.State = StatePending
+	}
+	return status
+}
+
+func WriteStatusTable(w io.Writer, statuses []MigrationStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tPHASE\tSTARTED AT\tCOMPLETED AT\tDURATION\tAPPLIED BY\tHOST\tTOOL VERSION\tAPP VERSION")
+	for _, s := range statuses {
+		duration := "-"
+		if s.CompletedAt != nil && s.StartedAt != nil {
+			duration = s.Duration.String()
+		}
+		state := string(s.State)
+		if s.Baseline {
+			state += " (baseline)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Id, state, orDash(string(s.Phase)), formatTime(s.StartedAt), formatTime(s.CompletedAt), duration,
+			orDash(s.AppliedBy), orDash(s.Hostname), orDash(s.ToolVersion), orDash(s.AppVersion))
+	}
+	return tw.Flush()
+}
+
+// migrationStatusFields has the fields of MigrationStatus without its JSON methods
+type migrationStatusFields MigrationStatus
+
+func (s MigrationStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		migrationStatusFields
+		DurationMs int64 `json:"duration_ms,omitempty"`
+	}{migrationStatusFields(s), s.Duration.Milliseconds()})
+}
+
+func (s *MigrationStatus) UnmarshalJSON(data []byte) error {
+	var v struct {
+		migrationStatusFields
+		DurationMs int64 `json:"duration_ms"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = MigrationStatus(v.migrationStatusFields)
+	s.Duration = time.Duration(v.DurationMs) * time.Millisecond
+	return nil
+}
+
+func WriteStatusJSON(w io.Writer, statuses []MigrationStatus) error {
+	if statuses == nil {
+		statuses = []MigrationStatus{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(statuses)
+}
+
+func valueOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,129 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	db, host, port := parentSession(t)
+
+	t.Run("Status should report all migrations as pending if the migrations table doesn't exist", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"create table test_table2(id text)"}},
+			}
+			statuses, err := Status(session, migrations, -1)
+			if err != nil {
+				t.Errorf("unable to get status: %s", err)
+			}
+			if len(statuses) != 2 || statuses[0].State != StatePending || statuses[1].State != StatePending {
+				t.Errorf("expected two pending migrations but got %v", statuses)
+			}
+			verifyTableExistence(t, session, "migrations", false)
+		})
+	})
+
+	t.Run("Status should report applied, pending, in-progress, stale and missing migrations", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)"}},
+				{Id: "002", Statements: []string{"create table test_table2(id text)"}},
+				{Id: "003", Statements: []string{"create table test_table3(id text)"}},
+				{Id: "004", Statements: []string{"create table test_table4(id text)"}},
+			}
+			initMigrationsTable(newExecutor(session))
+			currentTime := getCurrentTime(session)
+			markAsStarted(newExecutor(session), "000", currentTime.Add(-time.Minute))
+			markAsCompleted(newExecutor(session), "000", currentTime)
+			markAsStarted(newExecutor(session), "001", currentTime.Add(-time.Minute))
+			markAsCompleted(newExecutor(session), "001", currentTime)
+			markAsStarted(newExecutor(session), "002", currentTime)
+			markAsStarted(newExecutor(session), "003", currentTime.Add(-time.Hour))
+
+			statuses, err := Status(session, migrations, 60)
+			if err != nil {
+				t.Errorf("unable to get status: %s", err)
+			}
+			want := []struct {
+				id    string
+				state MigrationState
+			}{
+				{"001", StateApplied},
+				{"002", StateInProgress},
+				{"003", StateStale},
+				{"004", StatePending},
+				{"000", StateMissing},
+			}
+			if len(statuses) != len(want) {
+				t.Fatalf("got %d statuses, wanted %d: %v", len(statuses), len(want), statuses)
+			}
+			for i, w := range want {
+				if statuses[i].Id != w.id || statuses[i].State != w.state {
+					t.Errorf("got id=%s state=%s, wanted id=%s state=%s", statuses[i].Id, statuses[i].State, w.id, w.state)
+				}
+			}
+			if statuses[0].Duration != time.Minute {
+				t.Errorf("got duration %s, wanted %s", statuses[0].Duration, time.Minute)
+			}
+		})
+	})
+}
+
+func TestWriteStatus(t *testing.T) {
+	startedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	completedAt := startedAt.Add(2 * time.Second)
+	statuses := []MigrationStatus{
+		{
+			Id:          "001",
+			State:       StateApplied,
+			StartedAt:   &startedAt,
+			CompletedAt: &completedAt,
+			Duration:    2 * time.Second,
+			AppliedBy:   "postgres",
+			Hostname:    "pod-1",
+			ToolVersion: "v1.0.0",
+			Phase:       PhaseExpand,
+		},
+		{Id: "002", State: StatePending},
+	}
+
+	t.Run("WriteStatusTable should print a row per migration", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteStatusTable(&buf, statuses); err != nil {
+			t.Fatalf("unable to write table: %s", err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 3 {
+			t.Fatalf("expected header and 2 rows but got %q", buf.String())
+		}
+		if got := strings.Fields(lines[1]); strings.Join(got, " ") != "001 applied expand 2024-01-02T03:04:05Z 2024-01-02T03:04:07Z 2s postgres pod-1 v1.0.0 -" {
+			t.Errorf("unexpected row %q", lines[1])
+		}
+		if got := strings.Fields(lines[2]); strings.Join(got, " ") != "002 pending - - - - - - - -" {
+			t.Errorf("unexpected row %q", lines[2])
+		}
+	})
+
+	t.Run("WriteStatusJSON should print a JSON array", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteStatusJSON(&buf, statuses); err != nil {
+			t.Fatalf("unable to write json: %s", err)
+		}
+		if !strings.Contains(buf.String(), `"duration_ms": 2000`) {
+			t.Errorf("expected duration in milliseconds but got %s", buf.String())
+		}
+		var got []MigrationStatus
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("invalid json %q: %s", buf.String(), err)
+		}
+		if len(got) != 2 || got[0].Id != "001" || got[0].State != StateApplied || got[0].Duration != 2*time.Second || got[0].Hostname != "pod-1" || got[1].StartedAt != nil {
+			t.Errorf("unexpected statuses %v", got)
+		}
+	})
+}
@@ -0,0 +1,2 @@
+-- pgmigrate: no-transaction lock-timeout=5s statement-timeout=1m
+create index concurrently cars_brand_idx on cars (brand);
@@ -0,0 +1 @@
+create or replace view car_brands as select brand from cars;
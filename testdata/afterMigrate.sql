@@ -0,0 +1 @@
+grant select on all tables in schema public to test_user;
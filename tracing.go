@@ -0,0 +1,54 @@
+package pgmigrate
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/emillamm/pgmigrate"
+
+// maxStatementAttrLength limits the size of the SQL attribute recorded on statement spans
+const maxStatementAttrLength = 1024
+
+const (
+	attrMigrationId    = attribute.Key("pgmigrate.migration.id")
+	attrStatementIndex = attribute.Key("pgmigrate.statement.index")
+	attrPending        = attribute.Key("pgmigrate.migrations.pending")
+	attrCompleted      = attribute.Key("pgmigrate.migrations.completed")
+	attrQueryText      = attribute.Key("db.query.text")
+	attrDbSystem       = attribute.Key("db.system.name")
+)
+
+func startStatementSpan(ctx context.Context, tracer trace.Tracer, migrationId string, index int, statement string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "pgmigrate.statement", trace.WithAttributes(
+		attrDbSystem.String("postgresql"),
+		attrMigrationId.String(migrationId),
+		attrStatementIndex.Int(index),
+		attrQueryText.String(truncateStatement(statement)),
+	))
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func truncateStatement(s string) string {
+	if len(s) <= maxStatementAttrLength {
+		return s
+	}
+	s = s[:maxStatementAttrLength]
+	// Avoid cutting a multi-byte character in half
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s + "..."
+}
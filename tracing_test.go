@@ -0,0 +1,82 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	db, host, port := parentSession(t)
+
+	t.Run("RunMigrationsContext should record spans for the run, each migration and each statement", func(t *testing.T) {
+		ephemeralSession(t, db, host, port, func(session *sql.DB) {
+			exporter := tracetest.NewInMemoryExporter()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+			migrations := []Migration{
+				{Id: "001", Statements: []string{"create table test_table1(id text)", "create table test_table2(id text)"}},
+				{Id: "002", Statements: []string{"invalid"}},
+			}
+			if _, err := RunMigrationsContext(context.Background(), session, migrations, -1, WithTracerProvider(provider)); err == nil {
+				t.Errorf("expected migration 002 to fail")
+			}
+
+			spans := exporter.GetSpans()
+			byName := map[string][]tracetest.SpanStub{}
+			for _, s := range spans {
+				byName[s.Name] = append(byName[s.Name], s)
+			}
+			if len(byName["pgmigrate.run"]) != 1 || len(byName["pgmigrate.migration"]) != 2 || len(byName["pgmigrate.statement"]) != 3 {
+				t.Fatalf("unexpected spans %v", byName)
+			}
+
+			run := byName["pgmigrate.run"][0]
+			if run.Status.Code != codes.Error {
+				t.Errorf("expected run span to record the error")
+			}
+			for _, m := range byName["pgmigrate.migration"] {
+				if m.Parent.SpanID() != run.SpanContext.SpanID() {
+					t.Errorf("expected migration span to be a child of the run span")
+				}
+			}
+			failed := byName["pgmigrate.statement"][2]
+			if failed.Status.Code != codes.Error || len(failed.Events) == 0 {
+				t.Errorf("expected failed statement span to record the error")
+			}
+			var id, query string
+			for _, a := range failed.Attributes {
+				switch a.Key {
+				case attrMigrationId:
+					id = a.Value.AsString()
+				case attrQueryText:
+					query = a.Value.AsString()
+				}
+			}
+			if id != "002" || query != "invalid" {
+				t.Errorf("got id=%s query=%s, wanted id=002 query=invalid", id, query)
+			}
+		})
+	})
+}
+
+func TestTruncateStatement(t *testing.T) {
+	short := "create table test_table1(id text)"
+	if got := truncateStatement(short); got != short {
+		t.Errorf("truncateStatement() = %q, want %q", got, short)
+	}
+
+	long := strings.Repeat("é", maxStatementAttrLength)
+	got := truncateStatement(long)
+	if len(got) > maxStatementAttrLength+len("...") || !strings.HasSuffix(got, "...") {
+		t.Errorf("truncateStatement() returned %d bytes", len(got))
+	}
+	if strings.ContainsRune(got, '�') {
+		t.Errorf("truncateStatement() cut a character in half")
+	}
+}
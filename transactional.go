@@ -0,0 +1,87 @@
+package pgmigrate
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// nonTransactionalStatement matches statements that fail inside a transaction block
+type nonTransactionalStatement struct {
+	pattern *regexp.Regexp
+	reason  string
+	// The statement runs inside a transaction block from this server_version_num on, if non-zero
+	transactionalSince int
+}
+
+var nonTransactionalStatements = []nonTransactionalStatement{
+	{
+		pattern: regexp.MustCompile(`(?i)^(?:create\s+(?:unique\s+)?|drop\s+)index\s+concurrently\b`),
+		reason:  "concurrent index operations can't run inside a transaction block",
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)^reindex\b.*\bconcurrently\b`),
+		reason:  "reindex concurrently can't run inside a transaction block",
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)^alter\s+table\b.*\bdetach\s+partition\b.*\bconcurrently\b`),
+		reason:  "detach partition concurrently can't run inside a transaction block",
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)^vacuum\b`),
+		reason:  "vacuum can't run inside a transaction block",
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)^(?:create|drop)\s+database\b`),
+		reason:  "create and drop database can't run inside a transaction block",
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)^(?:create|drop)\s+tablespace\b`),
+		reason:  "create and drop tablespace can't run inside a transaction block",
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)^alter\s+system\b`),
+		reason:  "alter system can't run inside a transaction block",
+	},
+	{
+		pattern:            regexp.MustCompile(`(?i)^alter\s+type\b.*\badd\s+value\b`),
+		reason:             "alter type ... add value can't run inside a transaction block before PostgreSQL 12",
+		transactionalSince: 120000,
+	},
+}
+
+// findNonTransactionalStatement returns the index of the first statement that can't run inside a
+// transaction block on the connected server along with the reason, or -1 if there is none. The
+// server version is only looked up when it makes a difference.
+func findNonTransactionalStatement(ctx context.Context, session querier, statements []string) (index int, reason string, err error) {
+	serverVersion := -1
+	for i, s := range statements {
+		nt, ok := classifyStatement(s)
+		if !ok {
+			continue
+		}
+		if nt.transactionalSince > 0 {
+			if serverVersion < 0 {
+				if err = session.QueryRow(ctx, "select current_setting('server_version_num')::int;").Scan(&serverVersion); err != nil {
+					return -1, "", err
+				}
+			}
+			if serverVersion >= nt.transactionalSince {
+				continue
+			}
+		}
+		return i, nt.reason, nil
+	}
+	return -1, "", nil
+}
+
+// classifyStatement returns the kind of non-transactional statement that the statement matches, if any
+func classifyStatement(statement string) (nonTransactionalStatement, bool) {
+	statement = strings.TrimSpace(statement)
+	for _, nt := range nonTransactionalStatements {
+		if nt.pattern.MatchString(statement) {
+			return nt, true
+		}
+	}
+	return nonTransactionalStatement{}, false
+}
@@ -0,0 +1,33 @@
+package pgmigrate
+
+import "testing"
+
+func TestClassifyStatement(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      bool
+	}{
+		{"create index concurrently test_idx on test_table1 (id);", true},
+		{"  CREATE UNIQUE INDEX CONCURRENTLY test_idx ON test_table1 (id);", true},
+		{"drop index concurrently test_idx;", true},
+		{"reindex index concurrently test_idx;", true},
+		{"alter table test_table1 detach partition test_table1_2024 concurrently;", true},
+		{"vacuum analyze test_table1;", true},
+		{"create database test_database;", true},
+		{"drop tablespace test_space;", true},
+		{"alter system set work_mem = '64MB';", true},
+		{"alter type test_enum add value 'c';", true},
+		{"create index test_idx on test_table1 (id);", false},
+		{"create table test_vacuum(id text);", false},
+		{"alter type test_enum rename value 'a' to 'b';", false},
+		{"insert into test_table1 values ('vacuum');", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.statement, func(t *testing.T) {
+			if _, got := classifyStatement(tt.statement); got != tt.want {
+				t.Errorf("classifyStatement() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}